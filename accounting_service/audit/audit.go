@@ -0,0 +1,41 @@
+// Package audit records balance adjustments in the hash-chained audit log
+// the accounting service shares with the gateway.
+package audit
+
+import (
+    "encoding/json"
+    "log/slog"
+
+    "accounting_service/auditlog"
+    "accounting_service/database"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Service names the accounting service in the entries it writes.
+const Service = "accounting"
+
+// ActionBalanceUpdate is an adjustment of a user's balance.
+const ActionBalanceUpdate = "balance.update"
+
+// Record appends an entry for an action the caller of c took on target. The
+// caller is the one identity.Middleware verified. Failures are logged: the
+// action already happened and is not undone.
+func Record(c *gin.Context, action, target string, before, after any) {
+    ctx := c.Request.Context()
+    e := &auditlog.Entry{
+        Service:   Service,
+        Actor:     c.GetString("actor"),
+        ActorRole: c.GetString("actorRole"),
+        Action:    action,
+        Target:    target,
+        IP:        c.ClientIP(),
+        RequestID: c.GetString("requestID"),
+    }
+    e.Before, _ = json.Marshal(before)
+    e.After, _ = json.Marshal(after)
+
+    if err := auditlog.Append(ctx, database.DB, e); err != nil {
+        slog.ErrorContext(ctx, "Failed to write audit entry", "action", action, "target", target, "error", err)
+    }
+}
@@ -0,0 +1,148 @@
+// Package auditlog appends the accounting service's entries to the
+// hash-chained audit log it shares with the gateway. It is a copy of the
+// gateway's auditlog package without verification, which the gateway does;
+// Entry, Hash and the lock must stay identical to the gateway's or the chain
+// breaks. The golden test in both packages checks the hash format.
+package auditlog
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "time"
+
+    "gorm.io/gorm"
+)
+
+// Entry records one administrative action of the gateway or the accounting
+// service. Entries are only ever appended: each Hash covers the entry and
+// the Hash of the entry before it, so changing or removing a row breaks the
+// chain from there on.
+type Entry struct {
+    ID        uint64          `json:"id" gorm:"primaryKey;autoIncrement:false"` // Position in the chain, starting at 1
+    CreatedAt time.Time       `json:"createdAt" gorm:"not null;index"`
+    Service   string          `json:"service" gorm:"not null"`                  // gateway or accounting
+    Actor     string          `json:"actor" gorm:"not null;index"`              // Username of the caller
+    ActorRole string          `json:"actorRole"`                                // Role of the caller
+    Action    string          `json:"action" gorm:"not null;index"`             // e.g. user.create
+    Target    string          `json:"target" gorm:"index"`                      // What was acted on, e.g. the username
+    Before    json.RawMessage `json:"before" gorm:"type:jsonb;serializer:json"` // Changed fields before the action
+    After     json.RawMessage `json:"after" gorm:"type:jsonb;serializer:json"`  // Changed fields after the action
+    IP        string          `json:"ip"`
+    RequestID string          `json:"requestId" gorm:"index"`
+    PrevHash  string          `json:"prevHash" gorm:"not null"`
+    Hash      string          `json:"hash" gorm:"not null;uniqueIndex"`
+}
+
+// TableName keeps entries in audit_entries.
+func (Entry) TableName() string {
+    return "audit_entries"
+}
+
+// lockKey is the Postgres advisory lock serializing appends, so concurrent
+// writers in either service never fork the chain.
+const lockKey = 0x61756469746c6f67 // "auditlog"
+
+// appendOnlySQL makes Postgres reject updates and deletes of audit entries,
+// whoever issues them.
+const appendOnlySQL = `
+CREATE OR REPLACE FUNCTION audit_entries_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_entries is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_entries_append_only ON audit_entries;
+CREATE TRIGGER audit_entries_append_only
+    BEFORE UPDATE OR DELETE ON audit_entries
+    FOR EACH ROW EXECUTE FUNCTION audit_entries_append_only();
+
+DROP TRIGGER IF EXISTS audit_entries_no_truncate ON audit_entries;
+CREATE TRIGGER audit_entries_no_truncate
+    BEFORE TRUNCATE ON audit_entries
+    FOR EACH STATEMENT EXECUTE FUNCTION audit_entries_append_only();
+`
+
+// Migrate creates the audit table and makes it append-only. The advisory
+// lock keeps services starting together from racing on the triggers.
+func Migrate(db *gorm.DB) error {
+    return db.Transaction(func(tx *gorm.DB) error {
+        if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error; err != nil {
+            return err
+        }
+        if err := tx.AutoMigrate(&Entry{}); err != nil {
+            return err
+        }
+        return tx.Exec(appendOnlySQL).Error
+    })
+}
+
+// Append chains e to the last entry and stores it.
+func Append(ctx context.Context, db *gorm.DB, e *Entry) error {
+    return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+        if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error; err != nil {
+            return err
+        }
+
+        var prev Entry
+        if err := tx.Order("id DESC").Limit(1).Find(&prev).Error; err != nil {
+            return err
+        }
+
+        e.ID = prev.ID + 1
+        e.PrevHash = prev.Hash
+        // Postgres keeps microseconds; hashing more would not verify.
+        e.CreatedAt = time.Now().UTC().Truncate(time.Microsecond)
+        e.Hash = Hash(e)
+        return tx.Create(e).Error
+    })
+}
+
+// hashed is the content an entry's hash covers, in a fixed field order.
+type hashed struct {
+    ID        uint64          `json:"id"`
+    CreatedAt string          `json:"createdAt"`
+    Service   string          `json:"service"`
+    Actor     string          `json:"actor"`
+    ActorRole string          `json:"actorRole"`
+    Action    string          `json:"action"`
+    Target    string          `json:"target"`
+    Before    json.RawMessage `json:"before"`
+    After     json.RawMessage `json:"after"`
+    IP        string          `json:"ip"`
+    RequestID string          `json:"requestId"`
+    PrevHash  string          `json:"prevHash"`
+}
+
+// Hash returns the hex SHA-256 of e's content and the previous hash. Before
+// and After are hashed in canonical form, since jsonb does not keep the
+// bytes that were written.
+func Hash(e *Entry) string {
+    b, _ := json.Marshal(hashed{
+        ID:        e.ID,
+        CreatedAt: e.CreatedAt.UTC().Format(time.RFC3339Nano),
+        Service:   e.Service,
+        Actor:     e.Actor,
+        ActorRole: e.ActorRole,
+        Action:    e.Action,
+        Target:    e.Target,
+        Before:    canonical(e.Before),
+        After:     canonical(e.After),
+        IP:        e.IP,
+        RequestID: e.RequestID,
+        PrevHash:  e.PrevHash,
+    })
+    sum := sha256.Sum256(b)
+    return hex.EncodeToString(sum[:])
+}
+
+// canonical re-encodes raw with sorted keys and no insignificant space.
+func canonical(raw json.RawMessage) json.RawMessage {
+    var v any
+    if len(raw) == 0 || json.Unmarshal(raw, &v) != nil {
+        return json.RawMessage("null")
+    }
+    b, _ := json.Marshal(v)
+    return b
+}
@@ -1,8 +1,11 @@
 package config
 
 import (
-    "log"
+    "log/slog"
     "os"
+    "time"
+
+    "accounting_service/logging"
 
     "github.com/joho/godotenv"
 )
@@ -12,16 +15,29 @@ var (
     DatabaseURL string
     // Port is the port where the accounting service runs.
     Port string
+    // ShutdownDelay is how long the service reports not-ready before it stops
+    // accepting connections; ShutdownTimeout then bounds the wait for
+    // in-flight charges.
+    ShutdownDelay   time.Duration
+    ShutdownTimeout time.Duration
+    // IdentitySecret verifies the caller identities the gateway signs. Balance
+    // updates are refused while it is unset.
+    IdentitySecret string
+    // LogLevel is the minimum level of the JSON logs.
+    LogLevel slog.Level
+    // TracesExporter selects where spans go: "none", "otlp" (configured by
+    // the standard OTEL_EXPORTER_OTLP_* variables) or "stdout".
+    TracesExporter string
 )
 
 func LoadConfig() {
     if err := godotenv.Load(); err != nil {
-        log.Println("No .env file found, continuing with system env")
+        slog.Info("No .env file found, continuing with system env")
     }
 
     DatabaseURL = os.Getenv("DATABASE_URL")
     if DatabaseURL == "" {
-        log.Fatal("DATABASE_URL is not set")
+        logging.Fatal("DATABASE_URL is not set")
     }
 
     Port = os.Getenv("PORT")
@@ -29,4 +45,41 @@ func LoadConfig() {
         // Default port if not set
         Port = "8082"
     }
+
+    IdentitySecret = os.Getenv("IDENTITY_SECRET")
+
+    ShutdownDelay = durationEnv("SHUTDOWN_DELAY", 5*time.Second)
+    ShutdownTimeout = durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+
+    LogLevel = slog.LevelInfo
+    if v := os.Getenv("LOG_LEVEL"); v != "" {
+        if err := LogLevel.UnmarshalText([]byte(v)); err != nil {
+            logging.Fatal("Error parsing LOG_LEVEL", "value", v, "error", err)
+        }
+    }
+
+    TracesExporter = os.Getenv("OTEL_TRACES_EXPORTER")
+    if TracesExporter == "" {
+        TracesExporter = "none"
+    }
+    if TracesExporter == "console" {
+        TracesExporter = "stdout"
+    }
+    if TracesExporter != "none" && TracesExporter != "otlp" && TracesExporter != "stdout" {
+        logging.Fatal("OTEL_TRACES_EXPORTER must be none, otlp or stdout", "value", TracesExporter)
+    }
+}
+
+// durationEnv parses the duration in the named variable, or returns def when unset.
+func durationEnv(name string, def time.Duration) time.Duration {
+    v := os.Getenv(name)
+    if v == "" {
+        return def
+    }
+
+    d, err := time.ParseDuration(v)
+    if err != nil {
+        logging.Fatal("Error parsing "+name, "value", v, "error", err)
+    }
+    return d
 }
@@ -1,12 +1,15 @@
 package database
 
 import (
-    "log"
+    "log/slog"
 
+    "github.com/uptrace/opentelemetry-go-extra/otelgorm"
     "gorm.io/driver/postgres"
     "gorm.io/gorm"
 
+    "accounting_service/auditlog"
     "accounting_service/config"
+    "accounting_service/logging"
 )
 
 var DB *gorm.DB
@@ -34,11 +37,31 @@ func InitDB() {
     var err error
     DB, err = gorm.Open(postgres.Open(config.DatabaseURL), &gorm.Config{})
     if err != nil {
-        log.Fatal("Failed to connect to database:", err)
+        logging.Fatal("Failed to connect to database", "error", err)
+    }
+
+    // Queries run with a request context show up as spans of its trace.
+    if err := DB.Use(otelgorm.NewPlugin(otelgorm.WithoutQueryVariables(), otelgorm.WithoutMetrics())); err != nil {
+        logging.Fatal("Failed to instrument database", "error", err)
     }
 
     // Auto-migrate models.
     if err := DB.AutoMigrate(&User{}, &AccountingRule{}); err != nil {
-        log.Fatal("Failed to auto migrate database:", err)
+        logging.Fatal("Failed to auto migrate database", "error", err)
+    }
+    if err := auditlog.Migrate(DB); err != nil {
+        logging.Fatal("Failed to migrate audit entries", "error", err)
+    }
+}
+
+// Close closes the database connection pool.
+func Close() {
+    sqlDB, err := DB.DB()
+    if err != nil {
+        slog.Error("Failed to get database pool", "error", err)
+        return
+    }
+    if err := sqlDB.Close(); err != nil {
+        slog.Error("Failed to close database pool", "error", err)
     }
 }
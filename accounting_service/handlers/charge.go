@@ -3,6 +3,7 @@ package handlers
 import (
     "net/http"
 
+    "accounting_service/audit"
     "accounting_service/database"
     "github.com/gin-gonic/gin"
 )
@@ -20,16 +21,21 @@ func ChargeHandler(c *gin.Context) {
         return
     }
 
+    c.Set("user", req.Username)
+
+    // Queries join the trace of the charge request.
+    db := database.DB.WithContext(c.Request.Context())
+
     // Look up the accounting rule for the endpoint.
     var rule database.AccountingRule
-    if err := database.DB.Where("endpoint = ?", req.Endpoint).First(&rule).Error; err != nil {
+    if err := db.Where("endpoint = ?", req.Endpoint).First(&rule).Error; err != nil {
         c.JSON(http.StatusForbidden, gin.H{"error": "No accounting rule defined for this endpoint"})
         return
     }
 
     // Find the user.
     var user database.User
-    if err := database.DB.Where("username = ?", req.Username).First(&user).Error; err != nil {
+    if err := db.Where("username = ?", req.Username).First(&user).Error; err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
         return
     }
@@ -41,16 +47,24 @@ func ChargeHandler(c *gin.Context) {
     }
 
     user.Balance -= rule.Charge
-    if err := database.DB.Save(&user).Error; err != nil {
+    if err := db.Save(&user).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update balance"})
         return
     }
 
-    c.JSON(http.StatusOK, gin.H{"message": "Charge deducted", "new_balance": user.Balance})
+    c.Set("charged", rule.Charge)
+    c.JSON(http.StatusOK, gin.H{"message": "Charge deducted", "charged": rule.Charge, "new_balance": user.Balance})
 }
 
+// UpdateUserChargeHandler sets a user's balance. Only admins may, as vouched
+// for by the gateway through identity.Middleware.
 func UpdateUserChargeHandler(c *gin.Context) {
     username := c.Param("username")
+    c.Set("user", username)
+    if c.GetString("actorRole") != "admin" {
+        c.JSON(http.StatusForbidden, gin.H{"error": "Only admins may update balances"})
+        return
+    }
     var req struct {
         Charge float64 `json:"charge"`
     }
@@ -58,16 +72,19 @@ func UpdateUserChargeHandler(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON data"})
         return
     }
+    db := database.DB.WithContext(c.Request.Context())
     var user database.User
-    if err := database.DB.Where("username = ?", username).First(&user).Error; err != nil {
+    if err := db.Where("username = ?", username).First(&user).Error; err != nil {
         c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
         return
     }
+    previous := user.Balance
     user.Balance = req.Charge  // Make sure the User model has a Charge field; or if you meant Balance, update accordingly.
-    if err := database.DB.Save(&user).Error; err != nil {
+    if err := db.Save(&user).Error; err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user charge"})
         return
     }
+    audit.Record(c, audit.ActionBalanceUpdate, username, gin.H{"balance": previous}, gin.H{"balance": user.Balance})
     c.JSON(http.StatusOK, gin.H{"message": "User charge updated successfully"})
 }
 
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+    "context"
+    "net/http"
+    "time"
+
+    "accounting_service/database"
+    "github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the database check of the readiness probe.
+const readinessTimeout = 2 * time.Second
+
+// DependencyStatus is the outcome of one readiness check.
+type DependencyStatus struct {
+    Status string `json:"status"` // ok or error
+    Error  string `json:"error,omitempty"`
+}
+
+// HealthzHandler is the liveness probe: the process is up and serving.
+func HealthzHandler(c *gin.Context) {
+    c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadyzHandler returns the readiness probe. ready reports whether the
+// service is serving rather than starting or shutting down; Postgres must
+// answer as well.
+func ReadyzHandler(ready func() bool) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        checks := map[string]DependencyStatus{
+            "server":   {Status: "ok"},
+            "database": {Status: "ok"},
+        }
+        if !ready() {
+            checks["server"] = DependencyStatus{Status: "error", Error: "not serving or shutting down"}
+        }
+
+        ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+        defer cancel()
+        sqlDB, err := database.DB.DB()
+        if err == nil {
+            err = sqlDB.PingContext(ctx)
+        }
+        if err != nil {
+            checks["database"] = DependencyStatus{Status: "error", Error: err.Error()}
+        }
+
+        status, code := "ready", http.StatusOK
+        for _, check := range checks {
+            if check.Status != "ok" {
+                status, code = "not ready", http.StatusServiceUnavailable
+            }
+        }
+        c.JSON(code, gin.H{"status": status, "checks": checks})
+    }
+}
@@ -0,0 +1,39 @@
+// Package identity verifies the caller identity the gateway signs with the
+// shared IDENTITY_SECRET, for endpoints that must know who is acting.
+package identity
+
+import (
+    "errors"
+    "net/http"
+    "time"
+
+    "accounting_service/config"
+
+    "signedidentity"
+
+    "github.com/gin-gonic/gin"
+)
+
+// Middleware accepts requests carrying valid signed X-Auth-* headers and
+// stores the caller under "actor" and "actorRole".
+func Middleware(c *gin.Context) {
+    who, err := signedidentity.Verify(config.IdentitySecret, c.Request.Header, time.Now())
+    switch {
+    case errors.Is(err, signedidentity.ErrNoSecret):
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "IDENTITY_SECRET is not set"})
+        c.Abort()
+        return
+    case errors.Is(err, signedidentity.ErrMissing):
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+        c.Abort()
+        return
+    case err != nil:
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid identity headers", "details": err.Error()})
+        c.Abort()
+        return
+    }
+
+    c.Set("actor", who.User)
+    c.Set("actorRole", who.Role)
+    c.Next()
+}
@@ -0,0 +1,101 @@
+// Package logging sets up the accounting service's structured JSON logs.
+// Each request keeps the X-Request-ID the gateway sent, so its log lines
+// can be joined with the gateway's.
+package logging
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "log"
+    "log/slog"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+
+    "github.com/gin-gonic/gin"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// HeaderRequestID carries the request ID from the gateway.
+const HeaderRequestID = "X-Request-ID"
+
+// sensitive lists substrings of attribute keys whose values are never
+// logged.
+var sensitive = []string{"password", "secret", "token", "authorization", "cookie"}
+
+// Init makes a JSON logger writing to stdout at level the default for both
+// slog and the log package.
+func Init(level slog.Level) {
+    handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+        Level: level,
+        ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+            key := strings.ToLower(a.Key)
+            for _, s := range sensitive {
+                if strings.Contains(key, s) {
+                    return slog.String(a.Key, "[REDACTED]")
+                }
+            }
+            return a
+        },
+    })
+    log.SetFlags(0)
+    slog.SetDefault(slog.New(handler))
+}
+
+// Fatal logs msg and args at error level and exits, for failures the
+// service cannot start or keep running without.
+func Fatal(msg string, args ...any) {
+    slog.Error(msg, args...)
+    os.Exit(1)
+}
+
+// Middleware keeps or assigns the request ID and writes one access log line
+// per request. Handlers add fields by setting "user" and "charged" on the
+// context.
+func Middleware(c *gin.Context) {
+    start := time.Now()
+
+    id := c.GetHeader(HeaderRequestID)
+    if id == "" || len(id) > 128 {
+        b := make([]byte, 16)
+        rand.Read(b)
+        id = hex.EncodeToString(b)
+    }
+    c.Header(HeaderRequestID, id)
+    c.Set("requestID", id)
+
+    c.Next()
+
+    status := c.Writer.Status()
+    attrs := []slog.Attr{
+        slog.String("request_id", id),
+        slog.String("method", c.Request.Method),
+        slog.String("route", c.FullPath()),
+        slog.Int("status", status),
+        slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+    }
+    if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+        attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+    }
+    if user := c.GetString("user"); user != "" {
+        attrs = append(attrs, slog.String("user", user))
+    }
+    if charged, ok := c.Get("charged"); ok {
+        attrs = append(attrs, slog.Any("charged", charged))
+    }
+    if len(c.Errors) > 0 {
+        attrs = append(attrs, slog.String("errors", c.Errors.String()))
+    }
+
+    level := slog.LevelInfo
+    switch {
+    case status >= http.StatusInternalServerError:
+        level = slog.LevelError
+    case status >= http.StatusBadRequest:
+        level = slog.LevelWarn
+    case c.FullPath() == "/healthz" || c.FullPath() == "/readyz":
+        level = slog.LevelDebug
+    }
+    slog.LogAttrs(c.Request.Context(), level, "request", attrs...)
+}
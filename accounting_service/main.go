@@ -3,15 +3,61 @@ package main
 import (
     "accounting_service/config"
     "accounting_service/database"
+    "accounting_service/logging"
     "accounting_service/routes"
-    "log"
+    "accounting_service/tracing"
+    "context"
+    "errors"
+    "log/slog"
+    "net/http"
+    "os/signal"
+    "syscall"
+    "time"
 )
 
 func main() {
     config.LoadConfig()
+    logging.Init(config.LogLevel)
+    shutdownTracing, err := tracing.Init(context.Background())
+    if err != nil {
+        logging.Fatal("Failed to set up tracing", "error", err)
+    }
     database.InitDB()
     r := routes.SetupRoutes()
 
-    log.Println("Accounting service running on port " + config.Port)
-    r.Run(":" + config.Port)
+    server := &http.Server{Addr: ":" + config.Port, Handler: r}
+    errs := make(chan error, 1)
+    go func() { errs <- server.ListenAndServe() }()
+    routes.Ready.Store(true)
+    slog.Info("Accounting service running", "port", config.Port)
+
+    ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+    defer stop()
+
+    select {
+    case err := <-errs:
+        if !errors.Is(err, http.ErrServerClosed) {
+            logging.Fatal("Accounting service failed", "error", err)
+        }
+    case <-ctx.Done():
+    }
+
+    // Stop taking new charges but let in-flight ones commit before the
+    // database pool is closed.
+    routes.Ready.Store(false)
+    slog.Info("Shutting down: not ready, draining", "delay", config.ShutdownDelay.String())
+    time.Sleep(config.ShutdownDelay)
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+    defer cancel()
+    if err := server.Shutdown(shutdownCtx); err != nil {
+        slog.Warn("Accounting service did not drain in time", "error", err)
+        server.Close()
+    }
+
+    database.Close()
+    if err := shutdownTracing(context.Background()); err != nil {
+        slog.Error("Failed to flush traces", "error", err)
+    }
+    slog.Info("Accounting service stopped")
 }
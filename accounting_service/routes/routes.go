@@ -1,16 +1,29 @@
 package routes
 
 import (
+	"sync/atomic"
+
 	"accounting_service/handlers"
+	"accounting_service/identity"
+	"accounting_service/logging"
+	"accounting_service/tracing"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Ready reports whether the service accepts traffic. It is cleared when
+// shutdown starts.
+var Ready atomic.Bool
+
 func SetupRoutes() *gin.Engine {
-	r := gin.Default()
+	r := gin.New()
+	r.Use(tracing.Middleware(), logging.Middleware, gin.Recovery())
+
+	r.GET("/healthz", handlers.HealthzHandler)
+	r.GET("/readyz", handlers.ReadyzHandler(Ready.Load))
 
 	r.POST("/accounting/charge", handlers.ChargeHandler)
-	r.PUT("/accounting/users/:username/charge", handlers.UpdateUserChargeHandler)
+	r.PUT("/accounting/users/:username/charge", identity.Middleware, handlers.UpdateUserChargeHandler)
 
 	return r
 }
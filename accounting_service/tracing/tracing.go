@@ -0,0 +1,73 @@
+// Package tracing sets up OpenTelemetry tracing for the accounting service.
+// Charges continue the trace the gateway propagates in the traceparent
+// header. Exporters read the standard OTEL_EXPORTER_OTLP_* and
+// OTEL_SERVICE_NAME variables themselves.
+package tracing
+
+import (
+    "context"
+    "fmt"
+    "os"
+
+    "accounting_service/config"
+
+    "github.com/gin-gonic/gin"
+    "go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// ServiceName is reported when OTEL_SERVICE_NAME is unset.
+const ServiceName = "accounting"
+
+// Init installs the global tracer provider and propagator. The returned
+// function flushes pending spans and must be called before exiting.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+    otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+        propagation.TraceContext{},
+        propagation.Baggage{},
+    ))
+
+    var exporter sdktrace.SpanExporter
+    var err error
+    switch config.TracesExporter {
+    case "none":
+        return func(context.Context) error { return nil }, nil
+    case "otlp":
+        exporter, err = otlptracehttp.New(ctx)
+    case "stdout":
+        exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+    default:
+        return nil, fmt.Errorf("unknown traces exporter %q", config.TracesExporter)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    res, err := resource.Merge(
+        resource.NewSchemaless(semconv.ServiceName(ServiceName)),
+        resource.Default(),
+    )
+    if err != nil {
+        return nil, err
+    }
+
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(res),
+    )
+    otel.SetTracerProvider(provider)
+    return provider.Shutdown, nil
+}
+
+// Middleware starts a server span for each request except probes.
+func Middleware() gin.HandlerFunc {
+    return otelgin.Middleware(ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+        return c.FullPath() != "/healthz" && c.FullPath() != "/readyz"
+    }))
+}
@@ -0,0 +1,104 @@
+// Package audit records the gateway's administrative actions in the
+// hash-chained audit log, see auditlog.
+package audit
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+
+	"auth_service/auditlog"
+	"auth_service/caller"
+	"auth_service/database"
+	"auth_service/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Service names the gateway in the entries it writes.
+const Service = "gateway"
+
+// Audited actions.
+const (
+	ActionUserCreate            = "user.create"
+	ActionUserDelete            = "user.delete"
+	ActionUserRoleUpdate        = "user.role.update"
+	ActionUserCertificateUpdate = "user.certificate.update"
+	ActionRoleCreate            = "role.create"
+	ActionEndpointCreate        = "custom_endpoint.create"
+	ActionEndpointWeightsUpdate = "custom_endpoint.weights.update"
+	ActionCachePurge            = "cache.purge"
+	ActionQuotaSave             = "quota.save"
+	ActionQuotaDelete           = "quota.delete"
+)
+
+// Record appends an entry for an action the caller of c took on target.
+// before and after are the state of the target around the action, nil when
+// it did not exist; only the fields that differ are kept. Failures are
+// logged: the action already happened and is not undone.
+func Record(c *gin.Context, action, target string, before, after any) {
+	ctx := c.Request.Context()
+	e := &database.AuditEntry{
+		Service:   Service,
+		Action:    action,
+		Target:    target,
+		IP:        c.ClientIP(),
+		RequestID: logging.RequestID(ctx),
+	}
+	if who, ok := caller.FromContext(c); ok {
+		e.Actor, e.ActorRole = who.User, who.Role
+	}
+	e.Before, e.After = Diff(before, after)
+
+	if err := auditlog.Append(ctx, database.DB, e); err != nil {
+		slog.ErrorContext(ctx, "Failed to write audit entry", "action", action, "target", target, "error", err)
+	}
+}
+
+// Diff returns the fields of before and after, as encoded in JSON, whose
+// values differ. Values under sensitive names are redacted.
+func Diff(before, after any) (json.RawMessage, json.RawMessage) {
+	b, a := fields(before), fields(after)
+	for k, v := range b {
+		if w, ok := a[k]; ok && reflect.DeepEqual(v, w) {
+			delete(b, k)
+			delete(a, k)
+		}
+	}
+	return encode(b), encode(a)
+}
+
+// fields returns the JSON object v encodes to, or nil.
+func fields(v any) map[string]any {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if json.Unmarshal(raw, &m) != nil {
+		return nil
+	}
+	return m
+}
+
+func encode(m map[string]any) json.RawMessage {
+	if m == nil {
+		return nil
+	}
+	redact(m)
+	b, _ := json.Marshal(m)
+	return b
+}
+
+func redact(m map[string]any) {
+	for k, v := range m {
+		if logging.IsSensitive(k) {
+			m[k] = logging.Redacted
+		} else if nested, ok := v.(map[string]any); ok {
+			redact(nested)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package auditlog
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// goldenEntry and goldenHash pin the hash format. The accounting service
+// keeps a copy of this package with the same test: change both together.
+var goldenEntry = Entry{
+	ID:        7,
+	CreatedAt: time.Date(2025, 3, 1, 12, 30, 0, 123456000, time.UTC),
+	Service:   "accounting",
+	Actor:     "alice",
+	ActorRole: "admin",
+	Action:    "balance.update",
+	Target:    "bob",
+	Before:    json.RawMessage(`{"balance": 10, "currency": "EUR"}`),
+	After:     json.RawMessage(`{"currency":"EUR","balance":25.5}`),
+	IP:        "203.0.113.7",
+	RequestID: "req-1",
+	PrevHash:  "previous",
+}
+
+const goldenHash = "cf11bd648d9225e429c71fb74d1fb1ad51c4e49c61be756b7ff77921bf678143"
+
+func TestHashGolden(t *testing.T) {
+	if got := Hash(&goldenEntry); got != goldenHash {
+		t.Fatalf("Hash = %s, want %s", got, goldenHash)
+	}
+}
+
+func TestHashCoversContent(t *testing.T) {
+	reordered := goldenEntry
+	reordered.Before = json.RawMessage(`{"currency":"EUR","balance":10}`)
+	if Hash(&reordered) != Hash(&goldenEntry) {
+		t.Fatal("hash depends on the JSON key order jsonb does not keep")
+	}
+
+	changed := goldenEntry
+	changed.Actor = "mallory"
+	if Hash(&changed) == Hash(&goldenEntry) {
+		t.Fatal("hash does not cover the actor")
+	}
+}
@@ -0,0 +1,48 @@
+// Package cache stores upstream responses for the proxy. Entries live either
+// in an in-process LRU or in a backend shared by all gateway replicas.
+package cache
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Entry is a cached response.
+type Entry struct {
+	Status    int
+	Header    http.Header
+	Body      []byte
+	StoredAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Expired reports whether the entry is stale at now.
+func (e *Entry) Expired(now time.Time) bool {
+	return !now.Before(e.ExpiresAt)
+}
+
+// size approximates the memory held by the entry.
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	for k, values := range e.Header {
+		n += int64(len(k))
+		for _, v := range values {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+// Backend stores cache entries by key.
+type Backend interface {
+	// Get returns the fresh entry stored under key, or nil.
+	Get(ctx context.Context, key string) (*Entry, error)
+
+	// Set stores e under key until e.ExpiresAt.
+	Set(ctx context.Context, key string, e *Entry) error
+
+	// Purge removes every entry whose key starts with prefix and reports
+	// how many were removed.
+	Purge(ctx context.Context, prefix string) (int, error)
+}
@@ -0,0 +1,96 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+type lruItem struct {
+	key   string
+	entry *Entry
+	size  int64
+}
+
+// LRU is an in-process Backend that evicts the least recently used entries
+// once it holds more than its byte budget.
+type LRU struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	order    *list.List // Front is most recently used
+	items    map[string]*list.Element
+}
+
+// NewLRU returns an empty LRU holding at most maxBytes of entries.
+func NewLRU(maxBytes int64) *LRU {
+	return &LRU{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// Get implements Backend.
+func (l *LRU) Get(_ context.Context, key string) (*Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	el, ok := l.items[key]
+	if !ok {
+		return nil, nil
+	}
+	item := el.Value.(*lruItem)
+	if item.entry.Expired(time.Now()) {
+		l.remove(el)
+		return nil, nil
+	}
+	l.order.MoveToFront(el)
+	return item.entry, nil
+}
+
+// Set implements Backend. Entries larger than the whole budget are dropped.
+func (l *LRU) Set(_ context.Context, key string, e *Entry) error {
+	size := int64(len(key)) + e.size()
+	if size > l.maxBytes {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if el, ok := l.items[key]; ok {
+		l.remove(el)
+	}
+	l.items[key] = l.order.PushFront(&lruItem{key: key, entry: e, size: size})
+	l.size += size
+
+	for l.size > l.maxBytes {
+		l.remove(l.order.Back())
+	}
+	return nil
+}
+
+// Purge implements Backend.
+func (l *LRU) Purge(_ context.Context, prefix string) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	purged := 0
+	for key, el := range l.items {
+		if strings.HasPrefix(key, prefix) {
+			l.remove(el)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// remove drops el. Callers must hold l.mu.
+func (l *LRU) remove(el *list.Element) {
+	item := l.order.Remove(el).(*lruItem)
+	delete(l.items, item.key)
+	l.size -= item.size
+}
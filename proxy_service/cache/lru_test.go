@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// entry returns a fresh entry whose body is n bytes.
+func entry(n int) *Entry {
+	now := time.Now()
+	return &Entry{Status: 200, Body: []byte(strings.Repeat("x", n)), StoredAt: now, ExpiresAt: now.Add(time.Hour)}
+}
+
+func has(t *testing.T, l *LRU, key string) bool {
+	t.Helper()
+	e, err := l.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e != nil
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	// Room for three 10-byte bodies under 1-byte keys.
+	l := NewLRU(33)
+	for _, key := range []string{"a", "b", "c"} {
+		l.Set(ctx, key, entry(10))
+	}
+
+	// Using a makes b the least recently used.
+	if !has(t, l, "a") {
+		t.Fatal("a missing before eviction")
+	}
+	l.Set(ctx, "d", entry(10))
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if got := has(t, l, key); got != want {
+			t.Errorf("%s cached = %v, want %v", key, got, want)
+		}
+	}
+	if l.size > l.maxBytes {
+		t.Errorf("size %d over budget %d", l.size, l.maxBytes)
+	}
+}
+
+func TestLRUReplacesAndDropsOversizedEntries(t *testing.T) {
+	ctx := context.Background()
+	l := NewLRU(20)
+
+	l.Set(ctx, "a", entry(5))
+	l.Set(ctx, "a", entry(8))
+	if l.size != 9 || len(l.items) != 1 {
+		t.Fatalf("after replacing: size %d, %d items; want 9, 1", l.size, len(l.items))
+	}
+
+	l.Set(ctx, "big", entry(50))
+	if has(t, l, "big") || !has(t, l, "a") {
+		t.Fatal("an entry larger than the budget must be dropped without evicting others")
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	ctx := context.Background()
+	l := NewLRU(100)
+
+	stale := entry(5)
+	stale.ExpiresAt = time.Now().Add(-time.Second)
+	l.Set(ctx, "stale", stale)
+
+	if has(t, l, "stale") {
+		t.Fatal("expired entry served")
+	}
+	if l.size != 0 || len(l.items) != 0 {
+		t.Fatalf("expired entry kept: size %d, %d items", l.size, len(l.items))
+	}
+}
+
+func TestLRUPurge(t *testing.T) {
+	ctx := context.Background()
+	l := NewLRU(1000)
+	for _, key := range []string{"/sms/a#route=1", "/sms/b#route=1", "/smsx#route=2", "/mail#route=3"} {
+		l.Set(ctx, key, entry(1))
+	}
+
+	n, err := l.Purge(ctx, "/sms/")
+	if err != nil || n != 2 {
+		t.Fatalf("Purge = %d, %v; want 2", n, err)
+	}
+	for key, want := range map[string]bool{"/sms/a#route=1": false, "/sms/b#route=1": false, "/smsx#route=2": true, "/mail#route=3": true} {
+		if got := has(t, l, key); got != want {
+			t.Errorf("%s cached = %v, want %v", key, got, want)
+		}
+	}
+	if want := int64(len("/smsx#route=2") + len("/mail#route=3") + 2); l.size != want {
+		t.Errorf("size %d, want %d", l.size, want)
+	}
+}
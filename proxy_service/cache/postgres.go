@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"auth_service/database"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// pruneEvery is how many writes pass between sweeps of expired entries.
+const pruneEvery = 1000
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// PostgresStore keeps entries in the shared database (see
+// database.CacheEntry) so that all gateway replicas serve the same cache.
+type PostgresStore struct {
+	db     *gorm.DB
+	writes uint64
+}
+
+// NewPostgresStore returns a backend stored in db.
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Get implements Backend.
+func (s *PostgresStore) Get(ctx context.Context, key string) (*Entry, error) {
+	var row database.CacheEntry
+	err := s.db.WithContext(ctx).Where("key = ? AND expires_at > ?", key, time.Now()).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Entry{
+		Status:    row.Status,
+		Header:    row.Header,
+		Body:      row.Body,
+		StoredAt:  row.StoredAt,
+		ExpiresAt: row.ExpiresAt,
+	}, nil
+}
+
+// Set implements Backend.
+func (s *PostgresStore) Set(ctx context.Context, key string, e *Entry) error {
+	db := s.db.WithContext(ctx)
+	if atomic.AddUint64(&s.writes, 1)%pruneEvery == 0 {
+		db.Where("expires_at <= ?", time.Now()).Delete(&database.CacheEntry{})
+	}
+
+	return db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&database.CacheEntry{
+		Key:       key,
+		Status:    e.Status,
+		Header:    e.Header,
+		Body:      e.Body,
+		StoredAt:  e.StoredAt,
+		ExpiresAt: e.ExpiresAt,
+	}).Error
+}
+
+// Purge implements Backend.
+func (s *PostgresStore) Purge(ctx context.Context, prefix string) (int, error) {
+	result := s.db.WithContext(ctx).Where(`key LIKE ? ESCAPE '\'`, likeEscaper.Replace(prefix)+"%").Delete(&database.CacheEntry{})
+	return int(result.RowsAffected), result.Error
+}
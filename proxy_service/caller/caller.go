@@ -0,0 +1,37 @@
+// Package caller reads the authenticated caller of a request from the JWT
+// claims the authentication middlewares store on the gin context.
+package caller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// ClaimsKey is the gin context key the caller's jwt.MapClaims are stored
+// under.
+const ClaimsKey = "claims"
+
+// Caller is the authenticated user of a request.
+type Caller struct {
+	User   string
+	Role   string
+	Tenant string
+}
+
+// FromContext returns the caller of c. ok is false when the request carries
+// no claims. Claims that are missing or not strings are left empty.
+func FromContext(c *gin.Context) (Caller, bool) {
+	claimsVal, exists := c.Get(ClaimsKey)
+	if !exists {
+		return Caller{}, false
+	}
+	claims, ok := claimsVal.(jwt.MapClaims)
+	if !ok {
+		return Caller{}, false
+	}
+	var caller Caller
+	caller.User, _ = claims["user"].(string)
+	caller.Role, _ = claims["role"].(string)
+	caller.Tenant, _ = claims["tenant"].(string)
+	return caller, true
+}
@@ -1,10 +1,14 @@
 package config
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
+	"auth_service/logging"
+
 	"github.com/joho/godotenv"
 )
 
@@ -14,12 +18,65 @@ var (
 	// TLSPath is used for read TLS files from that path.
 	TLSPath string
 
+	// TLSMode is "tls" to serve HTTPS, or "plain" to serve plain HTTP and
+	// h2c behind a TLS-terminating load balancer.
+	TLSMode string
+
+	// TLSCertFile and TLSKeyFile name the default server certificate and key
+	// under TLSPath.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// TLSSNICerts lists further certificate and key names under TLSPath,
+	// picked by the server name clients ask for.
+	TLSSNICerts []CertKeyPair
+
+	// TLSReloadInterval is how often certificate files are checked for
+	// changes; 0 reloads on SIGHUP only.
+	TLSReloadInterval time.Duration
+
+	// HTTPRedirect starts a plain HTTP listener redirecting to HTTPS.
+	HTTPRedirect bool
+
+	// ClientCAFile names the PEM bundle under TLSPath that client
+	// certificates are verified against. Empty disables certificate
+	// authentication.
+	ClientCAFile string
+
 	// SecretKey is used for signing JWT tokens.
 	SecretKey string
 
 	// tokenExpirationPeriod is the duration for which the JWT token is valid.
 	TokenExpirationPeriod time.Duration
 
+	// IdentitySecret signs the identity headers and internal tokens sent to
+	// upstream services. Upstreams verify them with the same secret instead
+	// of re-parsing client JWTs.
+	IdentitySecret string
+
+	// InternalTokenTTL is the lifetime of internal tokens sent to upstreams.
+	InternalTokenTTL time.Duration
+
+	// RateLimitStore selects where rate-limit state lives: "memory" (per
+	// replica) or "postgres" (shared by all replicas).
+	RateLimitStore string
+
+	// CacheStore selects where cached responses live: "memory" (an LRU per
+	// replica) or "postgres" (shared by all replicas).
+	CacheStore string
+
+	// CacheMaxBytes bounds the in-memory response cache.
+	CacheMaxBytes int64
+
+	// QuotaLocation is the time zone whose calendar days and months bound
+	// usage-quota windows.
+	QuotaLocation *time.Location
+
+	// QuotaCacheTTL is how long quota definitions are cached by each
+	// replica before being read again. Changes made through another
+	// replica apply after at most this long; 0 disables the cache.
+	QuotaCacheTTL time.Duration
+
 	// AccountingEndpoint is the URL for the Accounting-Service
 	AccountingEndpoint string
 
@@ -32,41 +89,131 @@ var (
 	DatabasePassword string
 
 	DatabaseName string
+
+	// Server timeouts applied to the gateway's HTTP server.
+	ServerReadTimeout       time.Duration
+	ServerReadHeaderTimeout time.Duration
+	ServerWriteTimeout      time.Duration
+	ServerIdleTimeout       time.Duration
+
+	// ShutdownDelay is how long the gateway reports not-ready before it
+	// stops accepting connections, so load balancers can take it out of
+	// rotation. ShutdownTimeout then bounds the wait for in-flight requests.
+	ShutdownDelay   time.Duration
+	ShutdownTimeout time.Duration
+
+	// MetricsAddr is the address of the internal listener serving /metrics,
+	// which is not served on the public listeners. "none" disables it.
+	MetricsAddr string
+
+	// LogLevel is the minimum level of the JSON logs.
+	LogLevel slog.Level
+
+	// TracesExporter selects where spans go: "none", "otlp" (configured by
+	// the standard OTEL_EXPORTER_OTLP_* variables) or "stdout".
+	TracesExporter string
 )
 
+// CertKeyPair names a certificate and its private key.
+type CertKeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
 // LoadConfig loads environment variables from a .env file.
 func LoadConfig() {
 	// Attempt to load .env only if it exists.
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found (or not needed), continuing with environment variables.")
+		slog.Info("No .env file found (or not needed), continuing with environment variables")
 	}
 
 	BaseApi = os.Getenv("BASE_API")
 	if BaseApi == "" {
-		log.Fatal("BASE_API is not set in .env file")
+		logging.Fatal("BASE_API is not set in .env file")
 	}
 
 	TLSPath = os.Getenv("TLS_PATH")
 	if BaseApi == "" {
-		log.Fatal("TLS_PATH is not set in .env file")
+		logging.Fatal("TLS_PATH is not set in .env file")
+	}
+
+	TLSMode = os.Getenv("TLS_MODE")
+	if TLSMode == "" {
+		TLSMode = "tls"
+	}
+	if TLSMode != "tls" && TLSMode != "plain" {
+		logging.Fatal("TLS_MODE must be tls or plain", "value", TLSMode)
 	}
 
+	TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	if TLSCertFile == "" {
+		TLSCertFile = "172.26.249.184.pem"
+	}
+	TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if TLSKeyFile == "" {
+		TLSKeyFile = "172.26.249.184-key.pem"
+	}
+
+	TLSSNICerts = nil
+	if v := os.Getenv("TLS_SNI_CERTS"); v != "" {
+		for _, pair := range strings.Split(v, ",") {
+			cert, key, ok := strings.Cut(strings.TrimSpace(pair), ":")
+			if !ok || cert == "" || key == "" {
+				logging.Fatal("TLS_SNI_CERTS entries must be cert:key", "value", pair)
+			}
+			TLSSNICerts = append(TLSSNICerts, CertKeyPair{CertFile: cert, KeyFile: key})
+		}
+	}
+
+	TLSReloadInterval = durationEnv("TLS_RELOAD_INTERVAL", time.Minute)
+	HTTPRedirect = os.Getenv("HTTP_REDIRECT") == "true"
+
+	ClientCAFile = os.Getenv("CLIENT_CA_FILE")
+
 	SecretKey = os.Getenv("SECRET_KEY")
 	if SecretKey == "" {
-		log.Fatal("SECRET_KEY is not set in .env file")
+		logging.Fatal("SECRET_KEY is not set in .env file")
 	}
 
 	p := os.Getenv("TOKEN_EXPIRATION_PERIOD")
 	if SecretKey == "" {
-		log.Fatal("TOKEN_EXPIRATION_PERIOD is not set in .env file")
+		logging.Fatal("TOKEN_EXPIRATION_PERIOD is not set in .env file")
 	}
 
 	var err error
 	TokenExpirationPeriod, err = time.ParseDuration(p)
 	if err != nil {
-		log.Fatalf("Error parsing %s: %v\n", p, err)
+		logging.Fatal("Error parsing TOKEN_EXPIRATION_PERIOD", "value", p, "error", err)
 	}
 
+	IdentitySecret = os.Getenv("IDENTITY_SECRET")
+	InternalTokenTTL = durationEnv("INTERNAL_TOKEN_TTL", time.Minute)
+
+	RateLimitStore = os.Getenv("RATE_LIMIT_STORE")
+	if RateLimitStore == "" {
+		RateLimitStore = "memory"
+	}
+	if RateLimitStore != "memory" && RateLimitStore != "postgres" {
+		logging.Fatal("RATE_LIMIT_STORE must be memory or postgres", "value", RateLimitStore)
+	}
+
+	CacheStore = os.Getenv("CACHE_STORE")
+	if CacheStore == "" {
+		CacheStore = "memory"
+	}
+	if CacheStore != "memory" && CacheStore != "postgres" {
+		logging.Fatal("CACHE_STORE must be memory or postgres", "value", CacheStore)
+	}
+	CacheMaxBytes = int64Env("CACHE_MAX_BYTES", 64<<20)
+
+	QuotaLocation = time.UTC
+	if tz := os.Getenv("QUOTA_TIMEZONE"); tz != "" {
+		if QuotaLocation, err = time.LoadLocation(tz); err != nil {
+			logging.Fatal("Error loading QUOTA_TIMEZONE", "value", tz, "error", err)
+		}
+	}
+	QuotaCacheTTL = durationEnv("QUOTA_CACHE_TTL", 30*time.Second)
+
 	AccountingEndpoint = os.Getenv("ACCOUNTING_ENDPOINT")
 	if AccountingEndpoint == "" {
 		// Default to local accounting port.
@@ -75,26 +222,85 @@ func LoadConfig() {
 
 	DatabaseHost = os.Getenv("DB_HOST")
 	if DatabaseHost == "" {
-		log.Fatal("DB_HOST is not set in .env file")
+		logging.Fatal("DB_HOST is not set in .env file")
 	}
 
 	DatabasePort = os.Getenv("DB_PORT")
 	if DatabasePort == "" {
-		log.Fatal("DB_PORT is not set in .env file")
+		logging.Fatal("DB_PORT is not set in .env file")
 	}
 
 	DatabaseUserName = os.Getenv("DB_USER_NAME")
 	if DatabaseUserName == "" {
-		log.Fatal("DB_USER_NAME is not set in .env file")
+		logging.Fatal("DB_USER_NAME is not set in .env file")
 	}
 
 	DatabasePassword = os.Getenv("DB_PASSWORD")
 	if DatabasePassword == "" {
-		log.Fatal("DB_PASSWORD is not set in .env file")
+		logging.Fatal("DB_PASSWORD is not set in .env file")
 	}
 
 	DatabaseName = os.Getenv("DB_NAME")
 	if DatabaseName == "" {
-		log.Fatal("DB_NAME is not set in .env file")
+		logging.Fatal("DB_NAME is not set in .env file")
+	}
+
+	ServerReadTimeout = durationEnv("SERVER_READ_TIMEOUT", 30*time.Second)
+	ServerReadHeaderTimeout = durationEnv("SERVER_READ_HEADER_TIMEOUT", 10*time.Second)
+	ServerWriteTimeout = durationEnv("SERVER_WRITE_TIMEOUT", 60*time.Second)
+	ServerIdleTimeout = durationEnv("SERVER_IDLE_TIMEOUT", 120*time.Second)
+
+	ShutdownDelay = durationEnv("SHUTDOWN_DELAY", 5*time.Second)
+	ShutdownTimeout = durationEnv("SHUTDOWN_TIMEOUT", 30*time.Second)
+
+	MetricsAddr = os.Getenv("METRICS_ADDR")
+	if MetricsAddr == "" {
+		MetricsAddr = ":9090"
+	}
+
+	LogLevel = slog.LevelInfo
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := LogLevel.UnmarshalText([]byte(v)); err != nil {
+			logging.Fatal("Error parsing LOG_LEVEL", "value", v, "error", err)
+		}
+	}
+
+	TracesExporter = os.Getenv("OTEL_TRACES_EXPORTER")
+	if TracesExporter == "" {
+		TracesExporter = "none"
+	}
+	if TracesExporter == "console" {
+		TracesExporter = "stdout"
+	}
+	if TracesExporter != "none" && TracesExporter != "otlp" && TracesExporter != "stdout" {
+		logging.Fatal("OTEL_TRACES_EXPORTER must be none, otlp or stdout", "value", TracesExporter)
+	}
+}
+
+// durationEnv parses the duration in the named variable, or returns def when unset.
+func durationEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logging.Fatal("Error parsing "+name, "value", v, "error", err)
+	}
+	return d
+}
+
+// int64Env parses the integer in the named variable, or returns def when unset.
+func int64Env(name string, def int64) int64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		logging.Fatal("Error parsing "+name, "value", v, "error", err)
 	}
+	return n
 }
@@ -2,10 +2,16 @@ package database
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"time"
 
+	"auth_service/auditlog"
 	"auth_service/config"
+	"auth_service/logging"
+	"auth_service/metrics"
 
+	"github.com/uptrace/opentelemetry-go-extra/otelgorm"
 	"gorm.io/driver/postgres"
     "github.com/lib/pq"
 	"gorm.io/gorm"
@@ -31,6 +37,10 @@ type User struct {
 	// RoleID   uint     // Foreign key to the Role table.
 	// RoleInfo Role     `gorm:"foreignKey:RoleID;constraint:OnUpdate:CASCADE,OnDelete:SET NULL;"`
 	Balance float64 `gorm:"default:0"`
+
+	// CertSubject maps a client certificate to the user: its subject common
+	// name or one of its DNS, email or URI SANs.
+	CertSubject *string `gorm:"uniqueIndex"`
 }
 
 // AccountingRule defines which endpoints require a balance check and their charge.
@@ -43,13 +53,108 @@ type AccountingRule struct {
 // CustomEndpoint represents a user-defined route configuration.
 type CustomEndpoint struct {
 	gorm.Model
-	Path   string `json:"path" gorm:"uniqueIndex;not null"` // e.g., "/sms/*path"
-	Method string `json:"method" gorm:"default:'ANY'"`      // HTTP Method ("GET", "POST", etc. or ANY)
+	Path   string `json:"path" gorm:"index:idx_custom_endpoints_path_priority;not null"` // e.g., "/sms/*path"
+	Method string `json:"method" gorm:"default:'ANY'"`                                   // HTTP Method ("GET", "POST", etc. or ANY)
+
+	// Endpoints sharing a path are told apart by their match rules and
+	// tried in descending priority.
+	Match    RouteMatch `json:"match" gorm:"type:jsonb;serializer:json"`                            // Host, SNI, header, query and method conditions
+	Priority int        `json:"priority" gorm:"index:idx_custom_endpoints_path_priority;default:0"` // Higher priorities are matched first
+
 	Endpoints      pq.StringArray `json:"endpoints" gorm:"type:text[];not null;default:'{}'"` // Target endpoints
 	NeedAccounting bool           `json:"needAccounting" gorm:"default:false"`                // Flag: true if route requires accounting check
 	Enabled        bool           `gorm:"default:true"`
+
+	Protocol string `json:"protocol" gorm:"default:'http'"` // Upstream protocol: http, h2c, h2 or grpc (HTTP/2, served at the server root)
+
+	// Load balancing among Endpoints.
+	LoadBalancing string        `json:"loadBalancing" gorm:"default:'random'"` // random, round_robin, weighted_round_robin, least_connections, consistent_hash or p2c
+	Weights       pq.Int64Array `json:"weights" gorm:"type:integer[]"`         // Per-endpoint weights, aligned with Endpoints (default 1)
+	HashHeader    string        `json:"hashHeader"`                            // consistent_hash: header to hash on; the username is used when empty
+
+	// Traffic splitting, used instead of Endpoints. Callers are assigned to
+	// a group by hashing the same key as consistent_hash.
+	TargetGroups     []TargetGroup     `json:"targetGroups" gorm:"type:jsonb;serializer:json"`     // Weighted groups of targets, e.g. stable and canary
+	TrafficOverrides []TrafficOverride `json:"trafficOverrides" gorm:"type:jsonb;serializer:json"` // Rules pinning requests to a group
+
+	HealthCheck HealthCheck `json:"healthCheck" gorm:"type:jsonb;serializer:json"` // Active and passive upstream health checking
+	Retry       RetryPolicy `json:"retry" gorm:"type:jsonb;serializer:json"`       // Retries and failover between Endpoints
+
+	CircuitBreaker CircuitBreaker `json:"circuitBreaker" gorm:"type:jsonb;serializer:json"` // Per-target circuit breaker
+
+	TLS       UpstreamTLS            `json:"tls" gorm:"type:jsonb;serializer:json"`       // TLS towards https targets: CA bundle, client certificate, SNI and minimum version
+	TargetTLS map[string]UpstreamTLS `json:"targetTls" gorm:"type:jsonb;serializer:json"` // Per-target TLS by target URL, replacing TLS for that target
+
+	Timeouts         Timeouts `json:"timeouts" gorm:"type:jsonb;serializer:json"` // Upstream connect, response-header and total timeouts
+	MaxRequestBytes  int64    `json:"maxRequestBytes"`                            // Largest accepted request body, 0 for no limit
+	MaxResponseBytes int64    `json:"maxResponseBytes"`                           // Largest accepted upstream response body, 0 for no limit
+
+	Identity Identity `json:"identity" gorm:"type:jsonb;serializer:json"` // Identity propagation to the upstream
+	Rewrite  Rewrite  `json:"rewrite" gorm:"type:jsonb;serializer:json"`  // Path, query, header and Host rewriting
+
+	Transform Transform `json:"transform" gorm:"type:jsonb;serializer:json"` // Request and response body transformations
+
+	RateLimit      RateLimit            `json:"rateLimit" gorm:"type:jsonb;serializer:json"`      // Default rate limit of the route
+	RoleRateLimits map[string]RateLimit `json:"roleRateLimits" gorm:"type:jsonb;serializer:json"` // Rate limits overriding RateLimit for callers with the given role
+
+	Cache     ResponseCache `json:"cache" gorm:"type:jsonb;serializer:json"`     // Opt-in caching of GET responses
+	Streaming Streaming     `json:"streaming" gorm:"type:jsonb;serializer:json"` // WebSocket and SSE connection settings
+
+	Mirror Mirror `json:"mirror" gorm:"type:jsonb;serializer:json"` // Shadowing of requests to a secondary upstream
+
+	ClientCertAuth bool `json:"clientCertAuth" gorm:"default:false"` // Accept client certificates mapped to a user instead of a JWT
+}
+
+// RateLimitBucket holds a token bucket shared by all gateway replicas.
+type RateLimitBucket struct {
+	Key       string  `gorm:"primaryKey"`
+	Tokens    float64 `gorm:"not null"`
+	Allowed   bool    `gorm:"not null"` // Whether the last request taking a token was allowed
+	UpdatedAt time.Time
+	FullAt    time.Time `gorm:"index"` // When the bucket has refilled and may be dropped
+}
+
+// RateLimitWindow counts requests of one fixed window for sliding-window limits.
+type RateLimitWindow struct {
+	Key         string    `gorm:"primaryKey"`
+	WindowStart time.Time `gorm:"primaryKey"`
+	Count       int       `gorm:"not null"`
 }
 
+// CacheEntry is a cached upstream response shared by all gateway replicas.
+type CacheEntry struct {
+	Key       string      `gorm:"primaryKey"`
+	Status    int         `gorm:"not null"`
+	Header    http.Header `gorm:"type:jsonb;serializer:json"`
+	Body      []byte
+	StoredAt  time.Time `gorm:"not null"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
+
+// Quota caps the calls a role, or a single user, may make to a custom
+// endpoint per calendar day or month. Role quotas apply to each user with
+// the role separately; a user's own quota replaces the role's quota for the
+// same endpoint and period.
+type Quota struct {
+	gorm.Model
+	Endpoint string `json:"endpoint" gorm:"not null;uniqueIndex:idx_quota_scope"` // Custom endpoint path without "/*path", e.g. "/sms"
+	Role     string `json:"role" gorm:"uniqueIndex:idx_quota_scope"`              // Role the quota applies to, or empty
+	Username string `json:"username" gorm:"uniqueIndex:idx_quota_scope"`          // User the quota applies to, or empty
+	Period   string `json:"period" gorm:"not null;uniqueIndex:idx_quota_scope"`   // daily or monthly
+	Limit    int64  `json:"limit" gorm:"column:max_calls;not null"`               // Calls allowed per period
+}
+
+// QuotaUsage counts the calls a user made against a quota in one calendar window.
+type QuotaUsage struct {
+	QuotaID     uint      `gorm:"primaryKey"`
+	Username    string    `gorm:"primaryKey"`
+	WindowStart time.Time `gorm:"primaryKey"`
+	Count       int64     `gorm:"not null"`
+}
+
+// AuditEntry is an entry of the audit log, see auditlog.Entry.
+type AuditEntry = auditlog.Entry
+
 // InitDB initializes the database and performs migrations.
 func InitDB() {
 	var err error
@@ -60,11 +165,47 @@ func InitDB() {
 
 	DB, err = gorm.Open(postgres.Open(connStr), &gorm.Config{})
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		logging.Fatal("Failed to connect to database", "error", err)
+	}
+	if sqlDB, err := DB.DB(); err == nil {
+		metrics.RegisterDB(sqlDB, config.DatabaseName)
+	}
+
+	// Queries run with a request context show up as spans of its trace.
+	// Bound values are left out: they include usernames and password hashes.
+	if err := DB.Use(otelgorm.NewPlugin(
+		otelgorm.WithDBName(config.DatabaseName),
+		otelgorm.WithoutQueryVariables(),
+		otelgorm.WithoutMetrics(),
+	)); err != nil {
+		logging.Fatal("Failed to instrument database", "error", err)
+	}
+
+	// Paths used to be unique; endpoints sharing a path now differ by their
+	// match rules.
+	if DB.Migrator().HasIndex(&CustomEndpoint{}, "idx_custom_endpoints_path") {
+		if err := DB.Migrator().DropIndex(&CustomEndpoint{}, "idx_custom_endpoints_path"); err != nil {
+			logging.Fatal("Failed to drop unique custom endpoint path index", "error", err)
+		}
 	}
 
 	// Auto-migrate models.
-	if err := DB.AutoMigrate(&User{}, &Role{}, &AccountingRule{}, &CustomEndpoint{}); err != nil {
-		log.Fatal("Failed to auto migrate database:", err)
+	if err := DB.AutoMigrate(&User{}, &Role{}, &AccountingRule{}, &CustomEndpoint{}, &RateLimitBucket{}, &RateLimitWindow{}, &Quota{}, &QuotaUsage{}, &CacheEntry{}); err != nil {
+		logging.Fatal("Failed to auto migrate database", "error", err)
+	}
+	if err := auditlog.Migrate(DB); err != nil {
+		logging.Fatal("Failed to migrate audit entries", "error", err)
+	}
+}
+
+// Close closes the database connection pool.
+func Close() {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		slog.Error("Failed to get database pool", "error", err)
+		return
+	}
+	if err := sqlDB.Close(); err != nil {
+		slog.Error("Failed to close database pool", "error", err)
 	}
 }
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Duration is a time.Duration that is stored and exchanged as a Go duration
+// string such as "500ms" or "10s".
+type Duration time.Duration
+
+// MarshalJSON encodes the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON accepts either a duration string or a number of nanoseconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(time.Duration(value))
+	case string:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration %s", string(b))
+	}
+	return nil
+}
+
+// Or returns d, or def when d is not set.
+func (d Duration) Or(def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
+	}
+	return time.Duration(d)
+}
+
+// HealthCheck configures active probing and passive ejection of a route's targets.
+type HealthCheck struct {
+	// Active probing, disabled when Path is empty.
+	Path               string   `json:"path"`               // e.g. "/healthz", relative to each target
+	Interval           Duration `json:"interval"`           // Time between probes (default 10s)
+	Timeout            Duration `json:"timeout"`            // Probe timeout (default 2s)
+	HealthyThreshold   int      `json:"healthyThreshold"`   // Consecutive successes to mark a target healthy (default 2)
+	UnhealthyThreshold int      `json:"unhealthyThreshold"` // Consecutive failures to mark a target unhealthy (default 3)
+
+	// Passive ejection, disabled when MaxFailures is 0.
+	MaxFailures int      `json:"maxFailures"` // Consecutive 5xx responses or connection errors before ejection
+	Cooldown    Duration `json:"cooldown"`    // How long an ejected target is skipped (default 30s)
+}
+
+// RetryPolicy configures retries and failover to another target of the route.
+// Connection failures are retried for any method since the upstream never saw
+// the request; retryable status codes and other errors honour IdempotentOnly.
+type RetryPolicy struct {
+	Attempts       int      `json:"attempts"`       // Total tries including the first one (0 or 1 disables retries)
+	Backoff        Duration `json:"backoff"`        // Delay before the first retry, doubled for each further one (default 100ms)
+	RetryOn        []int    `json:"retryOn"`        // Upstream status codes that trigger a retry, e.g. [502, 503, 504]
+	IdempotentOnly bool     `json:"idempotentOnly"` // Only retry GET, HEAD, OPTIONS, PUT and DELETE requests
+	MaxBodyBytes   int64    `json:"maxBodyBytes"`   // Largest request body buffered for replay (default 1MiB)
+}
+
+// CircuitBreaker configures the per-target circuit breaker of a route.
+type CircuitBreaker struct {
+	FailureRatio     float64  `json:"failureRatio"`     // Share of failed requests in a window that opens the circuit, e.g. 0.5 (0 disables)
+	MinRequests      int      `json:"minRequests"`      // Requests needed in a window before the ratio is evaluated (default 10)
+	Window           Duration `json:"window"`           // Length of the counting window (default 10s)
+	OpenTimeout      Duration `json:"openTimeout"`      // Recovery window spent open before trial requests are let through (default 30s)
+	HalfOpenRequests int      `json:"halfOpenRequests"` // Successful trial requests needed to close the circuit again (default 1)
+}
+
+// Timeouts bounds how long the proxy waits on a route's upstream.
+type Timeouts struct {
+	Connect        Duration `json:"connect"`        // Dial timeout per target (default 30s)
+	ResponseHeader Duration `json:"responseHeader"` // Wait for the upstream response headers (0: no limit)
+	Total          Duration `json:"total"`          // Deadline for the whole proxied request, retries included (0: no limit)
+}
+
+// Identity configures how the authenticated caller is passed to the upstream.
+type Identity struct {
+	Mode               string `json:"mode"`               // "" (none), "headers" for signed X-Auth-* headers or "token" for a short-lived internal JWT
+	StripAuthorization bool   `json:"stripAuthorization"` // Drop the client's Authorization header before proxying
+}
+
+// Rewrite configures how requests and responses are rewritten between the
+// client and the upstream. Path rules apply to the path below BaseApi, before
+// the target's own path is prepended.
+type Rewrite struct {
+	StripPrefix     string            `json:"stripPrefix"`     // Prefix removed from the path
+	ReplacePrefix   PrefixRewrite     `json:"replacePrefix"`   // Prefix swapped for another one
+	Regex           []RegexRewrite    `json:"regex"`           // Regular-expression rewrites applied in order
+	Query           map[string]string `json:"query"`           // Query parameters set on the upstream request
+	RequestHeaders  HeaderRules       `json:"requestHeaders"`  // Header changes on the upstream request
+	ResponseHeaders HeaderRules       `json:"responseHeaders"` // Header changes on the upstream response
+	Host            string            `json:"host"`            // "" keeps the client's Host, "target" uses the target's, anything else is sent as-is
+}
+
+// PrefixRewrite replaces the path prefix From with To.
+type PrefixRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RegexRewrite replaces matches of Pattern in the path with Replacement,
+// which may reference capture groups as $1 or ${name}. Replacements are
+// paths: a "?" or "#" is refused, query parameters are set with Query.
+type RegexRewrite struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// HeaderRules adds, overrides and removes HTTP headers, in that order.
+type HeaderRules struct {
+	Add    map[string]string `json:"add"`
+	Set    map[string]string `json:"set"`
+	Remove []string          `json:"remove"`
+}
+
+// Transform configures body transformations on a route. Bodies are only
+// buffered when steps are configured; otherwise they stream through.
+type Transform struct {
+	Request      []TransformStep `json:"request"`      // Steps applied to the request body, in order
+	Response     []TransformStep `json:"response"`     // Steps applied to non-empty JSON or form response bodies, in order
+	MaxBodyBytes int64           `json:"maxBodyBytes"` // Largest body that is transformed (default 1MiB)
+}
+
+// TransformStep is a single body transformation.
+type TransformStep struct {
+	Op          string      `json:"op"`          // set, delete, rename, template, json_to_form or form_to_json
+	Path        string      `json:"path"`        // Dotted JSON path for set, delete and rename, e.g. "data.items.0.id"
+	To          string      `json:"to"`          // rename: destination path
+	Value       interface{} `json:"value"`       // set: value to store
+	Template    string      `json:"template"`    // template: Go text/template rendered with the decoded body as "."
+	ContentType string      `json:"contentType"` // template: content type of the rendered body (default application/json)
+}
+
+// RateLimit limits how often callers may hit a route.
+type RateLimit struct {
+	Algorithm string   `json:"algorithm"` // token_bucket (default) or sliding_window
+	Requests  int      `json:"requests"`  // Requests allowed per Period, 0 disables the limit
+	Period    Duration `json:"period"`    // Length of the limit window (default 1m)
+	Burst     int      `json:"burst"`     // token_bucket: largest burst allowed (default Requests)
+	Key       string   `json:"key"`       // What is limited: user (default), role, api_key, ip or route
+}
+
+// ResponseCache configures caching of a route's GET responses. Upstream
+// Cache-Control is honoured: no-store, no-cache and private answers are not
+// stored (private ones are when VaryBy includes "user") and s-maxage or
+// max-age take precedence over TTL. Hits are answered ahead of quotas and
+// accounting, so they are neither counted against quotas nor charged; rate
+// limits still apply to them.
+type ResponseCache struct {
+	Enabled       bool     `json:"enabled"`
+	TTL           Duration `json:"ttl"`           // Lifetime of entries without s-maxage or max-age (default 1m)
+	MaxEntryBytes int64    `json:"maxEntryBytes"` // Largest response body stored (default 1MiB)
+	VaryBy        []string `json:"varyBy"`        // Caller attributes added to the key: user and/or role
+}
+
+// Streaming configures WebSocket and Server-Sent Events connections on a route.
+type Streaming struct {
+	IdleTimeout Duration `json:"idleTimeout"` // Close connections without traffic for this long (default 5m)
+}
+
+// TargetGroup is a named set of targets receiving a weighted share of a
+// route's traffic, e.g. "stable" and "canary".
+type TargetGroup struct {
+	Name      string   `json:"name"`
+	Endpoints []string `json:"endpoints"` // Target URLs of the group
+	Weights   []int64  `json:"weights"`   // Per-endpoint weights within the group, aligned with Endpoints (default 1)
+	Weight    int      `json:"weight"`    // Share of the route's traffic relative to the other groups
+}
+
+// TrafficOverride pins matching requests to a target group regardless of
+// the group weights, e.g. to send testers to the canary. A request matches
+// when any of the configured conditions holds.
+type TrafficOverride struct {
+	Group  string   `json:"group"`  // Target group the request is sent to
+	Header string   `json:"header"` // Request header to match
+	Cookie string   `json:"cookie"` // Cookie to match
+	Value  string   `json:"value"`  // Required header or cookie value; any value when empty
+	Users  []string `json:"users"`  // Usernames to match
+}
+
+// Mirror configures shadowing of a route's traffic to a secondary upstream.
+// Shadow requests are sent asynchronously and their responses discarded.
+// Only idempotent requests are mirrored unless NonIdempotent is set, since
+// replaying e.g. a POST may repeat its side effects on the shadow.
+type Mirror struct {
+	Target        string   `json:"target"`        // Shadow upstream URL, mirroring is disabled when empty
+	Percent       *float64 `json:"percent"`       // Share of requests mirrored, 0-100 (default 100 when unset; 0 pauses mirroring)
+	Timeout       Duration `json:"timeout"`       // Deadline of each shadow request (default 5s)
+	MaxBodyBytes  int64    `json:"maxBodyBytes"`  // Requests with larger bodies are not mirrored (default 1MiB)
+	MaxConcurrent int      `json:"maxConcurrent"` // Shadow requests in flight; further ones are dropped (default 100)
+	NonIdempotent bool     `json:"nonIdempotent"` // Also mirror POST, PATCH and other non-idempotent requests
+}
+
+// RouteMatch narrows the requests a custom endpoint serves beyond its path,
+// so several endpoints can share a path, e.g. one per virtual host or API
+// version. All configured conditions must hold. Host and SNI patterns may
+// start with "*." to match any subdomain.
+type RouteMatch struct {
+	Hosts   []string          `json:"hosts"`   // Allowed Host header names, without port
+	SNI     []string          `json:"sni"`     // Allowed TLS server names
+	Methods []string          `json:"methods"` // Allowed methods, overriding Method when set
+	Headers map[string]string `json:"headers"` // Required header values; "*" accepts any present value
+	Query   map[string]string `json:"query"`   // Required query parameter values; "*" accepts any present value
+}
+
+// UpstreamTLS configures TLS towards a route's https targets. Files are
+// names under config.TLSPath and are reloaded when they change on disk.
+type UpstreamTLS struct {
+	CAFile     string `json:"caFile"`     // PEM bundle of CAs trusted instead of the system roots
+	CertFile   string `json:"certFile"`   // PEM client certificate for mutual TLS
+	KeyFile    string `json:"keyFile"`    // PEM private key of CertFile
+	ServerName string `json:"serverName"` // SNI and verified name, overriding the target host
+	MinVersion string `json:"minVersion"` // Lowest accepted version: 1.0, 1.1, 1.2 (default) or 1.3
+}
+
+// IsZero reports whether no TLS setting is configured.
+func (t UpstreamTLS) IsZero() bool {
+	return t == UpstreamTLS{}
+}
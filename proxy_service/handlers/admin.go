@@ -4,10 +4,10 @@ import (
 	"net/http"
 	"time"
 
+	"auth_service/caller"
 	"auth_service/database"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 )
 
 // SwaggerAccountingRule defines the accounting rule model for Swagger.
@@ -67,21 +67,13 @@ type AdminDashboardResponse struct {
 // @Router       /admin/dashboard [get]
 func AdminDashboardHandler(c *gin.Context) {
 	// Ensure only admins can access this route.
-	claimsVal, exists := c.Get("claims")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token claims not found"})
-		c.Abort()
-		return
-	}
-	claims, ok := claimsVal.(jwt.MapClaims)
+	who, ok := caller.FromContext(c)
 	if !ok {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token claims not found"})
 		c.Abort()
 		return
 	}
-
-	role, ok := claims["role"].(string)
-	if !ok || role != "admin" {
+	if who.Role != "admin" {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access restricted to admins only"})
 		c.Abort()
 		return
@@ -1,10 +1,13 @@
 package handlers
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
+	"auth_service/audit"
+	"auth_service/config"
 	"auth_service/database"
 	"auth_service/middleware"
 	"auth_service/proxy"
@@ -12,47 +15,95 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func registerCustomEndpointDynamic(r *gin.RouterGroup, ep *database.CustomEndpoint) {
-	// Wrap the handler with the endpoint parameter.
-	wrappedHandler := func(c *gin.Context) {
-		proxy.ProxyToEndpoint(c, ep.Endpoints)
+// chainStages is the length of every custom endpoint's handler chain.
+const chainStages = 7
+
+// passThrough stands in for middleware an endpoint does not use, so all
+// chains have the same stages.
+func passThrough(c *gin.Context) {}
+
+// buildHandlersChain builds the middleware chain and proxy of a custom
+// endpoint. It fails when the endpoint's configuration is invalid.
+func buildHandlersChain(ep *database.CustomEndpoint) ([]gin.HandlerFunc, *proxy.Route, error) {
+	route, err := proxy.NewRoute(ep)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Build the handler chain for the dynamic route.
-	handlersChain := []gin.HandlerFunc{middleware.AuthMiddleware}
+	auth := middleware.AuthMiddleware
+	if ep.ClientCertAuth {
+		auth = middleware.ClientCertAuthMiddleware
+	}
+	handlersChain := []gin.HandlerFunc{middleware.GRPCErrorMiddleware, auth}
+
+	// Endpoints sharing a path keep separate counters.
+	scope := fmt.Sprintf("%s#%d", ep.Path, ep.ID)
+	rateLimit, err := middleware.RateLimitMiddleware(scope, ep.RateLimit, ep.RoleRateLimits)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rateLimit == nil {
+		rateLimit = passThrough
+	}
+	handlersChain = append(handlersChain, rateLimit)
+
+	// Requests the gateway refuses by itself are neither counted nor charged.
+	handlersChain = append(handlersChain, route.Admit)
+
+	// Quotas are counted before the charge so capped calls cost nothing.
+	handlersChain = append(handlersChain, middleware.QuotaMiddleware(ep.Path))
+
 	if ep.NeedAccounting {
 		handlersChain = append(handlersChain, middleware.DynamicAccountingMiddleware)
+	} else {
+		handlersChain = append(handlersChain, passThrough)
+	}
+
+	// Wrap the handler with the endpoint's route.
+	handlersChain = append(handlersChain, route.Proxy)
+
+	return handlersChain, route, nil
+}
+
+// registerCustomEndpointDynamic registers ep on r, or on grpcGroup (the
+// server root) for gRPC endpoints. Endpoints sharing a path are served by
+// one dispatcher choosing between them by their match rules.
+func registerCustomEndpointDynamic(r, grpcGroup *gin.RouterGroup, ep *database.CustomEndpoint) error {
+	// Build the handler chain for the dynamic route.
+	handlersChain, route, err := buildHandlersChain(ep)
+	if err != nil {
+		return err
+	}
+
+	if ep.Protocol == proxy.ProtocolGRPC {
+		r = grpcGroup
+	}
+
+	dispatcherFor(r, ep.Path).add(route, handlersChain)
+	proxy.Register(route)
+	var targets []string
+	for _, t := range route.Targets() {
+		targets = append(targets, t.URL.String())
 	}
-	handlersChain = append(handlersChain, wrappedHandler)
-
-	// Register based on the HTTP method.
-	switch ep.Method {
-	case "GET":
-		r.GET(ep.Path, handlersChain...)
-	case "POST":
-		r.POST(ep.Path, handlersChain...)
-	case "PUT":
-		r.PUT(ep.Path, handlersChain...)
-	case "DELETE":
-		r.DELETE(ep.Path, handlersChain...)
-	default:
-		r.Any(ep.Path, handlersChain...)
-	}
-	log.Printf("Registered dynamic route: %s [%s] -> %s", ep.Path, ep.Method, ep.Endpoints[0])
+	slog.Info("Registered dynamic route", "route", ep.Path, "method", ep.Method, "priority", ep.Priority, "targets", targets)
+	return nil
 }
 
 // func RegisterCustomEndpoints(r *gin.Engine) {
-func RegisterCustomEndpoints(routerGroup *gin.RouterGroup) {
+func RegisterCustomEndpoints(routerGroup, grpcGroup *gin.RouterGroup) {
 
 	var endpoints []database.CustomEndpoint
 	if err := database.DB.Where("enabled = ?", true).Find(&endpoints).Error; err != nil {
-		log.Println("Error fetching custom endpoints:", err)
+		slog.Error("Failed to fetch custom endpoints", "error", err)
 		return
 	}
 
 	for _, endpoint := range endpoints {
-		registerCustomEndpointDynamic(routerGroup, &endpoint)
+		if err := registerCustomEndpointDynamic(routerGroup, grpcGroup, &endpoint); err != nil {
+			slog.Error("Skipping dynamic route", "route", endpoint.Path, "id", endpoint.ID, "error", err)
+		}
 	}
+	routesLoaded.Store(true)
 }
 
 // SwaggerCustomEndpoint represents the payload for Custom Endpoint.
@@ -62,11 +113,61 @@ func RegisterCustomEndpoints(routerGroup *gin.RouterGroup) {
 // @Property method body string true "method of request"
 // @Property endpoints body []string true  "ID of the captcha challenge"
 // @Property needAccounting body string true  "Needs check accouting before redirect it"
+// @Property loadBalancing body string false "random, round_robin, weighted_round_robin, least_connections, consistent_hash or p2c"
+// @Property weights body []int false "Per-endpoint weights, aligned with endpoints"
+// @Property hashHeader body string false "Header consistent_hash is keyed on (username when empty)"
+// @Property targetGroups body []object false "Named, weighted groups of endpoints (name, endpoints, weights, weight), used instead of endpoints"
+// @Property trafficOverrides body []object false "Rules pinning requests to a group by header, cookie or user"
+// @Property healthCheck body object false "Active probe and passive ejection settings"
+// @Property retry body object false "Retry and failover policy"
+// @Property circuitBreaker body object false "Per-target circuit breaker settings"
+// @Property timeouts body object false "Upstream connect, responseHeader and total timeouts"
+// @Property tls body object false "Upstream TLS: caFile, certFile, keyFile (names under TLS_PATH, reloaded on change), serverName, minVersion"
+// @Property targetTls body object false "Upstream TLS per target URL, replacing tls for that target"
+// @Property maxRequestBytes body int false "Largest accepted request body (413 above)"
+// @Property maxResponseBytes body int false "Largest accepted upstream response body"
+// @Property identity body object false "Identity propagation: mode (headers or token) and stripAuthorization"
+// @Property rewrite body object false "Path prefix/regex, query, header and Host rewriting rules"
+// @Property transform body object false "Request and response body transformation steps"
+// @Property rateLimit body object false "Default rate limit of the route"
+// @Property roleRateLimits body object false "Rate limits per role, overriding rateLimit"
+// @Property cache body object false "Opt-in GET response caching: enabled, ttl, maxEntryBytes, varyBy (user, role)"
+// @Property streaming body object false "WebSocket and SSE settings: idleTimeout"
+// @Property mirror body object false "Traffic shadowing: target, percent, timeout, maxBodyBytes, maxConcurrent, nonIdempotent"
+// @Property match body object false "Request conditions for endpoints sharing a path: hosts, sni, methods, headers, query"
+// @Property priority body int false "Order in which endpoints sharing a path are matched, highest first"
+// @Property clientCertAuth body bool false "Accept client certificates mapped to a user in place of a JWT"
+// @Property protocol body string false "Upstream protocol: http (default), h2c, h2 or grpc (served at the server root, e.g. path /pkg.Service)"
 type SwaggerCustomEndpoint struct {
-	Path           string
-	Method         string
-	Endpoints      []string
-	NeedAccounting bool
+	Path             string
+	Method           string
+	Match            database.RouteMatch
+	Priority         int
+	Protocol         string
+	Endpoints        []string
+	NeedAccounting   bool
+	ClientCertAuth   bool
+	LoadBalancing    string
+	Weights          []int64
+	HashHeader       string
+	TargetGroups     []database.TargetGroup
+	TrafficOverrides []database.TrafficOverride
+	HealthCheck      database.HealthCheck
+	Retry            database.RetryPolicy
+	CircuitBreaker   database.CircuitBreaker
+	Timeouts         database.Timeouts
+	TLS              database.UpstreamTLS
+	TargetTLS        map[string]database.UpstreamTLS
+	MaxRequestBytes  int64
+	MaxResponseBytes int64
+	Identity         database.Identity
+	Rewrite          database.Rewrite
+	Transform        database.Transform
+	RateLimit        database.RateLimit
+	RoleRateLimits   map[string]database.RateLimit
+	Cache            database.ResponseCache
+	Streaming        database.Streaming
+	Mirror           database.Mirror
 }
 
 // CreateCustomEndpointHandler create custom endpoint.
@@ -81,7 +182,7 @@ type SwaggerCustomEndpoint struct {
 // @Failure      401      {object}  map[string]string  "Unauthorized: invalid credentials"
 // @Failure      500      {object}  map[string]string  "Server error during token generation"
 // @Router       /admin/customendpoints [post]
-func CreateCustomEndpointHandler(dynamicGroup *gin.RouterGroup) gin.HandlerFunc {
+func CreateCustomEndpointHandler(dynamicGroup, grpcGroup *gin.RouterGroup) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req database.CustomEndpoint
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -90,7 +191,11 @@ func CreateCustomEndpointHandler(dynamicGroup *gin.RouterGroup) gin.HandlerFunc
 		}
 
 		// Validate endpoints format
-		for _, endpoint := range req.Endpoints {
+		endpoints := append([]string(nil), req.Endpoints...)
+		for _, group := range req.TargetGroups {
+			endpoints = append(endpoints, group.Endpoints...)
+		}
+		for _, endpoint := range endpoints {
 			if endpoint == "" || !strings.HasPrefix(endpoint, "http") {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or missing endpoint URL"})
 				return
@@ -101,18 +206,165 @@ func CreateCustomEndpointHandler(dynamicGroup *gin.RouterGroup) gin.HandlerFunc
 			req.Method = "ANY"
 		}
 
+		if req.LoadBalancing == "" {
+			req.LoadBalancing = proxy.StrategyRandom
+		}
+
+		if len(req.Weights) > len(req.Endpoints) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "More weights than endpoints"})
+			return
+		}
+
 		req.Path += "/*path"
 		req.Enabled = true
 
+		if _, _, err := buildHandlersChain(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid custom endpoint", "details": err.Error()})
+			return
+		}
+
 		if err := database.DB.Create(&req).Error; err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create custom endpoint"})
 			return
 		}
+		audit.Record(c, audit.ActionEndpointCreate, fmt.Sprintf("%s#%d", req.Path, req.ID), nil, req)
 
 		c.JSON(http.StatusOK, gin.H{"message": "Custom endpoint created successfully", "endpoint": req})
 
-		registerCustomEndpointDynamic(dynamicGroup, &req)
+		if err := registerCustomEndpointDynamic(dynamicGroup, grpcGroup, &req); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to register dynamic route", "route", req.Path, "id", req.ID, "error", err)
+		}
 
 		c.Next()
 	}
 }
+
+// SwaggerRouteHealth represents the health of one dynamic route's targets.
+// swagger:model SwaggerRouteHealth
+type SwaggerRouteHealth struct {
+	ID      uint                 `json:"id"`
+	Path    string               `json:"path"`
+	Groups  []proxy.GroupStatus  `json:"groups"`
+	Targets []proxy.TargetStatus `json:"targets"`
+	Streams proxy.StreamStats    `json:"streams"`
+	Mirror  *proxy.MirrorStats   `json:"mirror,omitempty"`
+}
+
+// UpstreamHealthHandler reports the health of every dynamic route's targets.
+// @Summary      Upstream health
+// @Description  Lists each custom endpoint with the active-probe, passive-ejection and circuit-breaker state of its targets and its WebSocket/SSE connection counts and shadow-traffic comparison. Admins only.
+// @Tags         Admin
+// @Produce      json
+// @Success      200  {object}  map[string][]SwaggerRouteHealth
+// @Failure      401  {object}  map[string]string  "Unauthorized"
+// @Failure      403  {object}  map[string]string  "Not an admin"
+// @Security     ApiKeyAuth
+// @Router       /admin/customendpoints/health [get]
+func UpstreamHealthHandler(c *gin.Context) {
+	routes := []SwaggerRouteHealth{}
+	for _, route := range proxy.Routes() {
+		health := SwaggerRouteHealth{ID: route.ID, Path: route.Path, Groups: route.Groups(), Streams: route.Streams(), Mirror: route.Mirror()}
+		for _, target := range route.Targets() {
+			health.Targets = append(health.Targets, target.Status())
+		}
+		routes = append(routes, health)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"routes": routes})
+}
+
+// PurgeCacheHandler drops cached upstream responses.
+// @Summary      Purge response cache
+// @Description  Removes the cached responses of a custom endpoint (route, e.g. /sms) or those whose key starts with prefix, a client request path such as /api/sms/reports. Admins only.
+// @Tags         Admin
+// @Produce      json
+// @Param        route   query     string  false  "Custom endpoint path"
+// @Param        prefix  query     string  false  "Cache key prefix"
+// @Success      200     {object}  map[string]interface{}
+// @Failure      400     {object}  ErrorResponse
+// @Failure      500     {object}  ErrorResponse
+// @Security     ApiKeyAuth
+// @Router       /admin/cache [delete]
+func PurgeCacheHandler(c *gin.Context) {
+	route, prefix := c.Query("route"), c.Query("prefix")
+	if (route == "") == (prefix == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of route and prefix is required"})
+		return
+	}
+	if route != "" {
+		prefix = config.BaseApi + strings.TrimSuffix(strings.TrimSuffix(route, "/*path"), "/") + "/"
+	}
+
+	purged, err := proxy.PurgeCache(c.Request.Context(), prefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not purge cache", "details": err.Error()})
+		return
+	}
+	audit.Record(c, audit.ActionCachePurge, prefix, nil, gin.H{"purged": purged})
+	c.JSON(http.StatusOK, gin.H{"message": "Cache purged successfully", "purged": purged})
+}
+
+// SwaggerGroupWeights is the payload to shift traffic between target groups.
+// swagger:model SwaggerGroupWeights
+type SwaggerGroupWeights struct {
+	Weights map[string]int `json:"weights"`
+}
+
+// UpdateGroupWeightsHandler shifts traffic between the target groups of a
+// live custom endpoint, e.g. to roll a canary out step by step.
+// @Summary      Update target group weights
+// @Description  Sets the weights of the named target groups. The change applies to new requests immediately and is persisted. Admins only.
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        id       path      int                  true  "Custom endpoint ID"
+// @Param        request  body      SwaggerGroupWeights  true  "Weights by group name"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  ErrorResponse
+// @Failure      404      {object}  ErrorResponse
+// @Failure      500      {object}  ErrorResponse
+// @Security     ApiKeyAuth
+// @Router       /admin/customendpoints/{id}/weights [put]
+func UpdateGroupWeightsHandler(c *gin.Context) {
+	var req SwaggerGroupWeights
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Weights) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON payload"})
+		return
+	}
+
+	var ep database.CustomEndpoint
+	if err := database.DB.First(&ep, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Custom endpoint not found"})
+		return
+	}
+	if len(ep.TargetGroups) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Custom endpoint has no target groups"})
+		return
+	}
+
+	route, ok := proxy.RouteByID(ep.ID)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Custom endpoint is not registered"})
+		return
+	}
+	if err := route.SetGroupWeights(req.Weights); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid weights", "details": err.Error()})
+		return
+	}
+
+	before, after := map[string]int{}, map[string]int{}
+	for i, group := range ep.TargetGroups {
+		before[group.Name] = group.Weight
+		if weight, ok := req.Weights[group.Name]; ok {
+			ep.TargetGroups[i].Weight = weight
+		}
+		after[group.Name] = ep.TargetGroups[i].Weight
+	}
+	if err := database.DB.Model(&ep).Select("TargetGroups").Updates(&ep).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Weights applied but could not be saved", "details": err.Error()})
+		return
+	}
+	audit.Record(c, audit.ActionEndpointWeightsUpdate, fmt.Sprintf("%s#%d", ep.Path, ep.ID), gin.H{"weights": before}, gin.H{"weights": after})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Weights updated successfully", "groups": route.Groups()})
+}
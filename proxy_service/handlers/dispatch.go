@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+
+	"auth_service/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextDispatchEntry is the context key of the endpoint chosen for a request.
+const contextDispatchEntry = "dispatchEntry"
+
+var (
+	dispatchersMu sync.Mutex
+	dispatchers   = make(map[string]*routeDispatcher) // By full path
+)
+
+// routeDispatcher serves all custom endpoints registered on one path. Gin
+// allows a single handler chain per path, so the dispatcher picks the
+// endpoint whose match rules accept the request and then runs that
+// endpoint's chain stage by stage.
+type routeDispatcher struct {
+	mu      sync.RWMutex
+	entries []*dispatchEntry // Copied on write, in matching order
+}
+
+type dispatchEntry struct {
+	route    *proxy.Route
+	handlers []gin.HandlerFunc
+}
+
+// dispatcherFor returns the dispatcher of path on r, registering it with gin
+// the first time.
+func dispatcherFor(r *gin.RouterGroup, path string) *routeDispatcher {
+	dispatchersMu.Lock()
+	defer dispatchersMu.Unlock()
+
+	key := r.BasePath() + path
+	if d, ok := dispatchers[key]; ok {
+		return d
+	}
+
+	d := &routeDispatcher{}
+	handlers := []gin.HandlerFunc{d.dispatch}
+	for i := 0; i < chainStages; i++ {
+		handlers = append(handlers, d.stage(i))
+	}
+	r.Any(path, handlers...)
+	dispatchers[key] = d
+	return d
+}
+
+// add adds or replaces the chain of route.
+func (d *routeDispatcher) add(route *proxy.Route, handlers []gin.HandlerFunc) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entries := []*dispatchEntry{{route: route, handlers: handlers}}
+	for _, e := range d.entries {
+		if e.route.ID != route.ID {
+			entries = append(entries, e)
+		}
+	}
+
+	// Higher priority first, then the most specific rules, then the oldest.
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].route, entries[j].route
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if sa, sb := a.Matcher().Specificity(), b.Matcher().Specificity(); sa != sb {
+			return sa > sb
+		}
+		return a.ID < b.ID
+	})
+	d.entries = entries
+}
+
+// dispatch picks the endpoint serving the request.
+func (d *routeDispatcher) dispatch(c *gin.Context) {
+	d.mu.RLock()
+	entries := d.entries
+	d.mu.RUnlock()
+
+	for _, e := range entries {
+		if e.route.Matcher().Matches(c.Request) {
+			c.Set(contextDispatchEntry, e)
+			return
+		}
+	}
+	c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "No custom endpoint matches the request"})
+}
+
+// stage runs the i-th handler of the chosen endpoint's chain.
+func (d *routeDispatcher) stage(i int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		e := c.MustGet(contextDispatchEntry).(*dispatchEntry)
+		if i < len(e.handlers) {
+			e.handlers[i](c)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"auth_service/config"
+	"auth_service/database"
+	"auth_service/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds each dependency check of the readiness probe.
+const readinessTimeout = 2 * time.Second
+
+var (
+	// serving is set once the listeners are up and cleared when shutdown
+	// starts.
+	serving atomic.Bool
+
+	// routesLoaded is set once the custom endpoints were read from the
+	// database and registered.
+	routesLoaded atomic.Bool
+
+	readinessClient = &http.Client{Timeout: readinessTimeout}
+)
+
+// SetServing records whether the gateway accepts traffic.
+func SetServing(ok bool) {
+	serving.Store(ok)
+}
+
+// DependencyStatus is the outcome of one readiness check.
+// swagger:model DependencyStatus
+type DependencyStatus struct {
+	Status string `json:"status"` // ok or error
+	Error  string `json:"error,omitempty"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// ReadinessResponse is the readiness probe answer.
+// swagger:model ReadinessResponse
+type ReadinessResponse struct {
+	Status string                      `json:"status"` // ready or not ready
+	Checks map[string]DependencyStatus `json:"checks"`
+}
+
+// HealthzHandler is the liveness probe: the process is up and serving.
+// @Summary      Liveness probe
+// @Description  Answers 200 while the process runs. Dependencies are not checked.
+// @Tags         Health
+// @Produce      json
+// @Success      200  {object}  map[string]string
+// @Router       /healthz [get]
+func HealthzHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadyzHandler is the readiness probe: the gateway can serve proxied
+// traffic.
+// @Summary      Readiness probe
+// @Description  Checks that the gateway is not shutting down, Postgres answers, the accounting service is reachable and the custom endpoints are loaded. Answers 503 with the failing checks otherwise.
+// @Tags         Health
+// @Produce      json
+// @Success      200  {object}  ReadinessResponse
+// @Failure      503  {object}  ReadinessResponse
+// @Router       /readyz [get]
+func ReadyzHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) DependencyStatus{
+		"server":     checkServing,
+		"database":   checkDatabase,
+		"accounting": checkAccounting,
+		"routes":     checkRoutes,
+	}
+
+	resp := ReadinessResponse{Status: "ready", Checks: make(map[string]DependencyStatus, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) DependencyStatus) {
+			defer wg.Done()
+			status := check(ctx)
+			mu.Lock()
+			resp.Checks[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for _, status := range resp.Checks {
+		if status.Status != "ok" {
+			resp.Status = "not ready"
+			code = http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, resp)
+}
+
+func checkServing(context.Context) DependencyStatus {
+	if !serving.Load() {
+		return DependencyStatus{Status: "error", Error: "not serving or shutting down"}
+	}
+	return DependencyStatus{Status: "ok"}
+}
+
+func checkDatabase(ctx context.Context) DependencyStatus {
+	sqlDB, err := database.DB.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		return DependencyStatus{Status: "error", Error: err.Error()}
+	}
+	return DependencyStatus{Status: "ok"}
+}
+
+func checkAccounting(ctx context.Context) DependencyStatus {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config.AccountingEndpoint+"/healthz", nil)
+	if err != nil {
+		return DependencyStatus{Status: "error", Error: err.Error()}
+	}
+	resp, err := readinessClient.Do(req)
+	if err != nil {
+		return DependencyStatus{Status: "error", Error: err.Error()}
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return DependencyStatus{Status: "error", Error: fmt.Sprintf("accounting service answered %d", resp.StatusCode)}
+	}
+	return DependencyStatus{Status: "ok"}
+}
+
+func checkRoutes(context.Context) DependencyStatus {
+	if !routesLoaded.Load() {
+		return DependencyStatus{Status: "error", Error: "custom endpoints not loaded"}
+	}
+	return DependencyStatus{Status: "ok", Detail: fmt.Sprintf("%d routes", len(proxy.Routes()))}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"auth_service/audit"
+	"auth_service/caller"
+	"auth_service/database"
+	"auth_service/quota"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SwaggerQuotaRequest represents the payload to define a usage quota.
+// swagger:model SwaggerQuotaRequest
+// @Description Exactly one of role and username must be set.
+// @Property endpoint body string true "Custom endpoint path, e.g. /sms"
+// @Property role body string false "Role the quota applies to (each user separately)"
+// @Property username body string false "User the quota applies to, replacing their role's quota"
+// @Property period body string true "daily or monthly"
+// @Property limit body int true "Calls allowed per period"
+type SwaggerQuotaRequest struct {
+	Endpoint string `json:"endpoint"`
+	Role     string `json:"role"`
+	Username string `json:"username"`
+	Period   string `json:"period"`
+	Limit    int64  `json:"limit"`
+}
+
+// CreateQuotaHandler defines a usage quota on a custom endpoint.
+// @Summary      Create usage quota
+// @Description  Caps the calls a role or a user may make to a custom endpoint per calendar day or month. Defining the same endpoint, role/user and period again updates the limit. Admins only.
+// @Tags         Admin
+// @Accept       json
+// @Produce      json
+// @Param        request  body      SwaggerQuotaRequest  true  "Quota definition"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  ErrorResponse
+// @Failure      404      {object}  ErrorResponse  "Custom endpoint not found"
+// @Failure      500      {object}  ErrorResponse
+// @Security     ApiKeyAuth
+// @Router       /admin/quotas [post]
+func CreateQuotaHandler(c *gin.Context) {
+	var req SwaggerQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	req.Endpoint = quota.EndpointKey(strings.TrimSuffix(req.Endpoint, "/"))
+	if req.Endpoint == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Endpoint is required"})
+		return
+	}
+	if (req.Role == "") == (req.Username == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Exactly one of role and username is required"})
+		return
+	}
+	if !quota.ValidPeriod(req.Period) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Period must be daily or monthly"})
+		return
+	}
+	if req.Limit < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Limit must not be negative"})
+		return
+	}
+
+	var count int64
+	if err := database.DB.Model(&database.CustomEndpoint{}).Where("path = ?", req.Endpoint+"/*path").Count(&count).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up custom endpoint"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Custom endpoint not found"})
+		return
+	}
+
+	q := database.Quota{
+		Endpoint: req.Endpoint,
+		Role:     req.Role,
+		Username: req.Username,
+		Period:   req.Period,
+	}
+	var before *int64
+	var existing database.Quota
+	if database.DB.Where(&q, "Endpoint", "Role", "Username", "Period").Limit(1).Find(&existing).RowsAffected > 0 {
+		before = &existing.Limit
+	}
+	err := database.DB.Where(&q, "Endpoint", "Role", "Username", "Period").
+		Assign(database.Quota{Limit: req.Limit}).
+		FirstOrCreate(&q).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save quota", "details": err.Error()})
+		return
+	}
+	quota.Invalidate()
+	scope := gin.H{"endpoint": q.Endpoint, "role": q.Role, "username": q.Username, "period": q.Period}
+	if before == nil {
+		audit.Record(c, audit.ActionQuotaSave, quotaTarget(q), nil, gin.H{"scope": scope, "limit": q.Limit})
+	} else {
+		audit.Record(c, audit.ActionQuotaSave, quotaTarget(q), gin.H{"limit": *before}, gin.H{"limit": q.Limit})
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Quota saved successfully", "quota": q})
+}
+
+// quotaTarget names a quota in the audit log, e.g. "/sms role:guest daily".
+func quotaTarget(q database.Quota) string {
+	subject := "role:" + q.Role
+	if q.Username != "" {
+		subject = "user:" + q.Username
+	}
+	return q.Endpoint + " " + subject + " " + q.Period
+}
+
+// GetQuotasHandler lists all usage quotas.
+// @Summary      List usage quotas
+// @Tags         Admin
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  ErrorResponse
+// @Security     ApiKeyAuth
+// @Router       /admin/quotas [get]
+func GetQuotasHandler(c *gin.Context) {
+	var quotas []database.Quota
+	if err := database.DB.Order("endpoint, role, username, period").Find(&quotas).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quotas"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"quotas": quotas})
+}
+
+// DeleteQuotaHandler removes a usage quota and its counters.
+// @Summary      Delete usage quota
+// @Tags         Admin
+// @Produce      json
+// @Param        id   path      int  true  "Quota ID"
+// @Success      200  {object}  map[string]string
+// @Failure      404  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     ApiKeyAuth
+// @Router       /admin/quotas/{id} [delete]
+func DeleteQuotaHandler(c *gin.Context) {
+	id := c.Param("id")
+
+	var q database.Quota
+	if err := database.DB.First(&q, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quota not found"})
+		return
+	}
+
+	// Delete for good so the same scope can be defined again.
+	if err := database.DB.Unscoped().Delete(&q).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete quota"})
+		return
+	}
+	quota.Invalidate()
+	database.DB.Where("quota_id = ?", q.ID).Delete(&database.QuotaUsage{})
+	audit.Record(c, audit.ActionQuotaDelete, quotaTarget(q), gin.H{"endpoint": q.Endpoint, "role": q.Role, "username": q.Username, "period": q.Period, "limit": q.Limit}, nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Quota deleted successfully"})
+}
+
+// MyQuotasHandler shows the caller's remaining allowance.
+// @Summary      My usage quotas
+// @Description  Lists the quotas binding the caller with the calls used and remaining in the current day or month.
+// @Tags         Auth
+// @Produce      json
+// @Success      200  {object}  map[string][]quota.Status
+// @Failure      401  {object}  ErrorResponse
+// @Failure      500  {object}  ErrorResponse
+// @Security     ApiKeyAuth
+// @Router       /me/quotas [get]
+func MyQuotasHandler(c *gin.Context) {
+	who, ok := caller.FromContext(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token claims not found"})
+		return
+	}
+	username, role := who.User, who.Role
+	if username == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username in token"})
+		return
+	}
+
+	quotas, err := quota.Applicable(c.Request.Context(), "", username, role)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quotas"})
+		return
+	}
+	statuses, err := quota.Usage(c.Request.Context(), quotas, username, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quota usage"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"quotas": statuses})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 
+	"auth_service/audit"
 	"auth_service/database"
 	"github.com/gin-gonic/gin"
 )
@@ -89,6 +90,7 @@ func CreateRoleHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create role", "details": err.Error()})
 		return
 	}
+	audit.Record(c, audit.ActionRoleCreate, role.Name, nil, gin.H{"name": role.Name, "description": role.Description})
 	c.JSON(http.StatusOK, gin.H{"message": "Role created successfully", "role": role})
 }
 
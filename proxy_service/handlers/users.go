@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
+	"net/url"
 	"time"
 
+	"auth_service/audit"
 	"auth_service/config"
 	"auth_service/database"
+	"auth_service/metrics"
+	"auth_service/middleware"
 
 	// "github.com/dchest/captcha"
 	"github.com/gin-gonic/gin"
@@ -91,6 +96,7 @@ func RegisterHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create user", "details": err.Error()})
 		return
 	}
+	audit.Record(c, audit.ActionUserCreate, user.Username, nil, gin.H{"username": user.Username, "role": role.Name})
 
 	c.JSON(http.StatusOK, gin.H{"message": "User registered successfully"})
 }
@@ -151,12 +157,14 @@ func LoginHandler(c *gin.Context) {
          Preload("Role").
          Where("username = ?", req.Username).
          First(&user).Error; err != nil {
+         metrics.Login(metrics.LoginUnknownUser)
          c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found"})
          return
      }
 
 	// Compare the stored hashed password with the incoming password.
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		metrics.Login(metrics.LoginBadPassword)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid password"})
 		return
 	}
@@ -172,10 +180,12 @@ func LoginHandler(c *gin.Context) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	tokenStr, err := token.SignedString([]byte(config.SecretKey))
 	if err != nil {
+		metrics.Login(metrics.LoginError)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 		return
 	}
 
+	metrics.Login(metrics.LoginSuccess)
 	c.JSON(http.StatusOK, gin.H{"token": tokenStr})
 }
 
@@ -201,7 +211,7 @@ func DeleteUserHandler(c *gin.Context) {
 
 	// Find the user in the database.
 	var user database.User
-	if err := database.DB.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := database.DB.Preload("Role").Where("username = ?", username).First(&user).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -211,6 +221,7 @@ func DeleteUserHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete user", "details": err.Error()})
 		return
 	}
+	audit.Record(c, audit.ActionUserDelete, user.Username, gin.H{"username": user.Username, "role": user.Role.Name, "balance": user.Balance}, nil)
 
 	// Permanently delete the user to clear the unique constraint.
 	// if err := database.DB.Unscoped().Delete(&user).Error; err != nil {
@@ -275,6 +286,9 @@ func UpdateUserRoleHandler(c *gin.Context) {
 		return
 	}
 
+	var previous database.Role
+	database.DB.First(&previous, user.RoleID)
+
 	// Update the user's role.
 	user.RoleID = role.ID
 
@@ -282,6 +296,143 @@ func UpdateUserRoleHandler(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user role", "details": err.Error()})
 		return
 	}
+	audit.Record(c, audit.ActionUserRoleUpdate, user.Username, gin.H{"role": previous.Name}, gin.H{"role": role.Name})
 
 	c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
 }
+
+// BalanceUpdateRequest represents the payload for setting a user's balance. godoc
+// swagger:model BalanceUpdateRequest
+// @Description BalanceUpdateRequest defines the expected request body for balance updates.
+// @Property balance body number true "New balance of the user"
+type BalanceUpdateRequest struct {
+	Balance *float64 `json:"balance"`
+}
+
+// UpdateUserBalanceHandler allows an admin to set a user's balance. godoc
+// @Summary      Update user balance
+// @Description  Set the balance of an existing user (admin only). The accounting service applies the change and records it in the audit log under the caller's signed identity.
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        username  path      string                true  "Username to update"
+// @Param        request   body      BalanceUpdateRequest  true  "Balance update payload"
+// @Success      200       {object}  map[string]string  "User balance updated successfully"
+// @Failure      400       {object}  map[string]string  "Invalid input or missing fields"
+// @Failure      404       {object}  map[string]string  "User not found"
+// @Failure      500       {object}  map[string]string  "IDENTITY_SECRET is not set"
+// @Failure      502       {object}  map[string]string  "Error calling accounting service"
+// @Router       /users/{username}/balance [put]
+func UpdateUserBalanceHandler(c *gin.Context) {
+	username := c.Param("username")
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username is required"})
+		return
+	}
+
+	var req BalanceUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+	if req.Balance == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Balance is required"})
+		return
+	}
+
+	// The accounting service only trusts signed identities for balance
+	// updates, as it records the caller in the audit log.
+	if config.IdentitySecret == "" {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "IDENTITY_SECRET must be set to update balances"})
+		return
+	}
+
+	payload, _ := json.Marshal(gin.H{"charge": *req.Balance})
+	resp, err := middleware.AccountingRequest(c.Request.Context(), c, http.MethodPut, "/accounting/users/"+url.PathEscape(username)+"/charge", payload)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Error calling accounting service", "details": err.Error()})
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var body struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&body) != nil || body.Error == "" {
+			body.Error = "Accounting service rejected the balance update"
+		}
+		c.JSON(resp.StatusCode, gin.H{"error": body.Error})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User balance updated successfully"})
+}
+
+// CertificateUpdateRequest represents the payload for mapping a client
+// certificate to a user.
+// swagger:model CertificateUpdateRequest
+// @Description CertificateUpdateRequest defines the expected request body for certificate mapping updates.
+// @Property subject body string true "Certificate common name or DNS, email or URI SAN; empty to remove the mapping"
+type CertificateUpdateRequest struct {
+	Subject string `json:"subject"`
+}
+
+// UpdateUserCertificateHandler allows an admin to map a client certificate to a user. godoc
+// @Summary      Update user certificate
+// @Description  Map the client certificate with the given subject common name or SAN to a user, so they can authenticate with it on routes accepting client certificates (admin only)
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        username  path      string                    true  "Username to update"
+// @Param        request   body      CertificateUpdateRequest  true  "Certificate mapping payload"
+// @Success      200       {object}  map[string]string  "User certificate updated successfully"
+// @Failure      400       {object}  map[string]string  "Invalid input or missing fields"
+// @Failure      404       {object}  map[string]string  "User not found"
+// @Failure      409       {object}  map[string]string  "Certificate already mapped to another user"
+// @Failure      500       {object}  map[string]string  "Failed to update user certificate"
+// @Router       /users/{username}/certificate [put]
+func UpdateUserCertificateHandler(c *gin.Context) {
+	var req CertificateUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
+		return
+	}
+
+	var user database.User
+	if err := database.DB.Where("username = ?", c.Param("username")).First(&user).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	previous := user.CertSubject
+	user.CertSubject = nil
+	if req.Subject != "" {
+		if certSubjectTaken(req.Subject, user.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Certificate already mapped to another user"})
+			return
+		}
+		user.CertSubject = &req.Subject
+	}
+
+	if err := database.DB.Model(&user).Select("CertSubject").Updates(&user).Error; err != nil {
+		// The unique index refuses a subject mapped concurrently.
+		if req.Subject != "" && certSubjectTaken(req.Subject, user.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Certificate already mapped to another user"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update user certificate", "details": err.Error()})
+		return
+	}
+	audit.Record(c, audit.ActionUserCertificateUpdate, user.Username, gin.H{"certSubject": previous}, gin.H{"certSubject": user.CertSubject})
+
+	c.JSON(http.StatusOK, gin.H{"message": "User certificate updated successfully"})
+}
+
+// certSubjectTaken reports whether a user other than userID, including a
+// deleted one still holding the unique index entry, is mapped to subject.
+func certSubjectTaken(subject string, userID uint) bool {
+	var count int64
+	database.DB.Unscoped().Model(&database.User{}).Where("cert_subject = ? AND id <> ?", subject, userID).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,96 @@
+// Package logging sets up the gateway's structured JSON logs. Records logged
+// with a request context carry its request ID and trace ID, and attributes
+// that may hold credentials are redacted.
+package logging
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Redacted replaces the values of sensitive attributes and query parameters.
+const Redacted = "[REDACTED]"
+
+// sensitive lists substrings of attribute keys and query parameter names
+// whose values are never logged.
+var sensitive = []string{"password", "secret", "token", "authorization", "cookie", "api_key", "apikey"}
+
+// Init makes a JSON logger writing to stdout at level the default for both
+// slog and the log package.
+func Init(level slog.Level) {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redactAttr,
+	})
+	// Lines from the log package become Info records; the JSON handler
+	// adds the time itself.
+	log.SetFlags(0)
+	slog.SetDefault(slog.New(contextHandler{handler}))
+}
+
+// Fatal logs msg and args at error level and exits, for failures the
+// gateway cannot start or keep running without.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// IsSensitive reports whether values under name must not be logged.
+func IsSensitive(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range sensitive {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactQuery encodes query with the values of sensitive parameters
+// replaced.
+func RedactQuery(query url.Values) string {
+	redacted := make(url.Values, len(query))
+	for name, values := range query {
+		if IsSensitive(name) {
+			values = []string{Redacted}
+		}
+		redacted[name] = values
+	}
+	return redacted.Encode()
+}
+
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() != slog.KindGroup && IsSensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// contextHandler adds the request and trace IDs of the record's context.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
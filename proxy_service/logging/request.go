@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// HeaderRequestID carries the request ID between clients, the gateway, the
+// accounting service and upstreams.
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from clients.
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+type accessKey struct{}
+
+// WithRequestID returns ctx carrying the request ID id.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID of ctx, or "".
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a random 128-bit hex identifier.
+func NewRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ValidRequestID reports whether a client-supplied request ID may be kept:
+// it is short and only holds characters safe in headers and log lines.
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Access collects what handlers learn about a request for its access log
+// line: the upstream that served it and the amount charged.
+type Access struct {
+	mu       sync.Mutex
+	target   string
+	upstream time.Duration
+	attempts int
+	charged  float64
+	charge   bool
+}
+
+// WithAccess returns ctx carrying a.
+func WithAccess(ctx context.Context, a *Access) context.Context {
+	return context.WithValue(ctx, accessKey{}, a)
+}
+
+// RecordUpstream records an upstream attempt of the request in ctx. The last
+// target tried is the one reported.
+func RecordUpstream(ctx context.Context, target string, elapsed time.Duration) {
+	a, ok := ctx.Value(accessKey{}).(*Access)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.target = target
+	a.upstream += elapsed
+	a.attempts++
+}
+
+// RecordCharge records the amount charged for the request in ctx.
+func RecordCharge(ctx context.Context, amount float64) {
+	a, ok := ctx.Value(accessKey{}).(*Access)
+	if !ok {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.charged = amount
+	a.charge = true
+}
+
+// Upstream returns the target that served the request, the time spent
+// waiting on upstreams over all attempts and the number of attempts.
+func (a *Access) Upstream() (string, time.Duration, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.target, a.upstream, a.attempts
+}
+
+// Charged returns the amount charged, and false if the request was not
+// charged.
+func (a *Access) Charged() (float64, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.charged, a.charge
+}
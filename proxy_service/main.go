@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"log/slog"
+
 	"auth_service/config"
 	"auth_service/database"
+	"auth_service/logging"
 	"auth_service/routes"
+	"auth_service/tracing"
 )
 
 // @title Auth service API
@@ -23,10 +28,23 @@ import (
 func main() {
 	// Load configuration from .env.
 	config.LoadConfig()
+	logging.Init(config.LogLevel)
+
+	// Start tracing before the database so its queries are instrumented.
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
 
 	// Initialize the database.
 	database.InitDB()
 
-	// Setup routes.
+	// Setup routes. This returns once the servers have drained on SIGTERM.
 	routes.SetupRoutes(":8080", ":8443")
+
+	database.Close()
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
+	}
 }
@@ -0,0 +1,154 @@
+// Package metrics holds the gateway's Prometheus collectors. Labels only
+// carry bounded values: route patterns rather than raw paths, configured
+// target URLs and fixed outcome names.
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gateway"
+
+// Login outcomes.
+const (
+	LoginSuccess     = "success"
+	LoginUnknownUser = "unknown_user"
+	LoginBadPassword = "bad_password"
+	LoginError       = "error"
+)
+
+// Credential rejection reasons.
+const (
+	TokenMissing   = "missing_token"
+	TokenInvalid   = "invalid_token"
+	TokenBadClaims = "bad_claims"
+	CertNotMapped  = "cert_not_mapped"
+	CertAmbiguous  = "cert_ambiguous"
+)
+
+// Charge outcomes reported by the accounting service.
+const (
+	ChargeOK                  = "ok"
+	ChargeInsufficientBalance = "insufficient_balance"
+	ChargeNoRule              = "no_rule"
+	ChargeUnknownUser         = "unknown_user"
+	ChargeError               = "error"
+)
+
+// RouteUnmatched labels requests that matched no route.
+const RouteUnmatched = "unmatched"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests served, by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, by route pattern and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time until upstream response headers, per attempt, by route, target and outcome (status class or error).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "target", "outcome"})
+
+	circuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "circuit_state",
+		Help:      "State of each target's circuit breaker: 0 closed, 1 open, 2 half-open.",
+	}, []string{"route", "target"})
+
+	circuitOpens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "circuit_opens_total",
+		Help:      "Times a target's circuit breaker opened, by route and target.",
+	}, []string{"route", "target"})
+
+	logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts by outcome.",
+	}, []string{"outcome"})
+
+	authFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Rejected credentials on protected routes by reason.",
+	}, []string{"reason"})
+
+	charges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accounting_charges_total",
+		Help:      "Accounting charges requested by the gateway, by outcome.",
+	}, []string{"outcome"})
+)
+
+// ObserveRequest records a served request.
+func ObserveRequest(route, method string, status int, elapsed time.Duration) {
+	if route == "" {
+		route = RouteUnmatched
+	}
+	requests.WithLabelValues(route, method, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(route, method).Observe(elapsed.Seconds())
+}
+
+// ObserveUpstream records one upstream attempt. status is 0 when the
+// attempt failed without a response.
+func ObserveUpstream(route, target string, status int, elapsed time.Duration) {
+	outcome := "error"
+	if status > 0 {
+		outcome = strconv.Itoa(status/100) + "xx"
+	}
+	upstreamDuration.WithLabelValues(route, target, outcome).Observe(elapsed.Seconds())
+}
+
+// CircuitState records the state of a target's circuit breaker, as its
+// BreakerState value.
+func CircuitState(route, target string, state int) {
+	circuitState.WithLabelValues(route, target).Set(float64(state))
+}
+
+// CircuitOpened counts a target's circuit breaker opening.
+func CircuitOpened(route, target string) {
+	circuitOpens.WithLabelValues(route, target).Inc()
+}
+
+// Login counts a login attempt.
+func Login(outcome string) {
+	logins.WithLabelValues(outcome).Inc()
+}
+
+// AuthFailure counts rejected credentials.
+func AuthFailure(reason string) {
+	authFailures.WithLabelValues(reason).Inc()
+}
+
+// Charge counts an accounting charge.
+func Charge(outcome string) {
+	charges.WithLabelValues(outcome).Inc()
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB, name string) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
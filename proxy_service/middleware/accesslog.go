@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"auth_service/caller"
+	"auth_service/logging"
+
+	"github.com/gin-gonic/gin"
+)
+
+// quietRoutes are polled by probes and scrapers; their successful requests
+// are only logged at debug level.
+var quietRoutes = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// AccessLogMiddleware assigns the request its ID and writes one JSON access
+// log line once it is served. A valid X-Request-ID from the client is kept,
+// otherwise a new one is generated; either way it is returned to the client
+// and forwarded to the accounting service and upstreams.
+func AccessLogMiddleware(c *gin.Context) {
+	start := time.Now()
+
+	id := c.GetHeader(logging.HeaderRequestID)
+	if !logging.ValidRequestID(id) {
+		id = logging.NewRequestID()
+	}
+	c.Request.Header.Set(logging.HeaderRequestID, id)
+	c.Header(logging.HeaderRequestID, id)
+
+	access := &logging.Access{}
+	ctx := logging.WithAccess(logging.WithRequestID(c.Request.Context(), id), access)
+	c.Request = c.Request.WithContext(ctx)
+
+	c.Next()
+
+	status := c.Writer.Status()
+	attrs := []slog.Attr{
+		slog.String("method", c.Request.Method),
+		slog.String("route", c.FullPath()),
+		slog.String("path", c.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.Int("bytes", c.Writer.Size()),
+		slog.String("client_ip", c.ClientIP()),
+	}
+	if query := c.Request.URL.Query(); len(query) > 0 {
+		attrs = append(attrs, slog.String("query", logging.RedactQuery(query)))
+	}
+	if who, ok := caller.FromContext(c); ok {
+		attrs = append(attrs, slog.String("user", who.User), slog.String("role", who.Role))
+	}
+	if target, upstream, attempts := access.Upstream(); attempts > 0 {
+		attrs = append(attrs,
+			slog.String("target", target),
+			slog.Float64("upstream_ms", float64(upstream.Microseconds())/1000),
+			slog.Int("attempts", attempts),
+		)
+	}
+	if charged, ok := access.Charged(); ok {
+		attrs = append(attrs, slog.Float64("charged", charged))
+	}
+	if len(c.Errors) > 0 {
+		attrs = append(attrs, slog.String("errors", c.Errors.String()))
+	}
+
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	case quietRoutes[c.FullPath()]:
+		level = slog.LevelDebug
+	}
+	slog.LogAttrs(ctx, level, "request", attrs...)
+}
@@ -67,12 +67,20 @@ package middleware
 
 import (
     "bytes"
+    "context"
     "encoding/json"
     "net/http"
 
+    "auth_service/caller"
     "auth_service/config"
+    "auth_service/logging"
+    "auth_service/metrics"
+    "auth_service/proxy"
+    "auth_service/tracing"
     "github.com/gin-gonic/gin"
-    "github.com/golang-jwt/jwt/v5"
+    "go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
 )
 
 // ChargePayload represents the request we send to accounting service
@@ -85,30 +93,28 @@ type ChargePayload struct {
 // If the accounting service returns an error, the request is aborted.
 func DynamicAccountingMiddleware(c *gin.Context) {
     // Retrieve JWT claims.
-    claimsVal, exists := c.Get("claims")
-    if !exists {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Token claims not found"})
-        c.Abort()
-        return
-    }
-    claims, ok := claimsVal.(jwt.MapClaims)
+    who, ok := caller.FromContext(c)
     if !ok {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "Token claims not found"})
         c.Abort()
         return
     }
-    username, ok := claims["user"].(string)
-    if !ok {
+    username := who.User
+    if username == "" {
         c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid username in token"})
         c.Abort()
         return
     }
 
-    // Prepare the payload to send to the accounting service.
+    // Prepare the payload to send to the accounting service. gRPC calls are
+    // charged by method name, e.g. "/sms.SMSService/Send".
     payload := ChargePayload{
         Username: username,
         Endpoint: c.Request.URL.Path,
     }
+    if proxy.IsGRPCRequest(c.Request) {
+        payload.Endpoint = proxy.GRPCMethod(c.Request)
+    }
     jsonPayload, err := json.Marshal(payload)
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not marshal payload"})
@@ -119,14 +125,30 @@ func DynamicAccountingMiddleware(c *gin.Context) {
     // Call the accounting service.
     // Assume the accounting service URL is stored in config.AccountingEndpoint.
     // For example: "http://localhost:8082"
-    accountingURL := config.AccountingEndpoint + "/accounting/charge"
-    resp, err := http.Post(accountingURL, "application/json", bytes.NewBuffer(jsonPayload))
+    ctx, span := tracing.Start(c.Request.Context(), "accounting.charge")
+    resp, err := AccountingRequest(ctx, c, http.MethodPost, "/accounting/charge", jsonPayload)
     if err != nil {
+        metrics.Charge(metrics.ChargeError)
+        span.SetStatus(codes.Error, err.Error())
+        span.End()
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Error calling accounting service", "details": err.Error()})
         c.Abort()
         return
     }
-    defer resp.Body.Close()
+    var charge struct {
+        Charged *float64 `json:"charged"`
+    }
+    if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&charge) == nil && charge.Charged != nil {
+        logging.RecordCharge(ctx, *charge.Charged)
+    }
+    resp.Body.Close()
+    outcome := chargeOutcome(resp.StatusCode)
+    metrics.Charge(outcome)
+    span.SetAttributes(attribute.String("accounting.outcome", outcome))
+    if resp.StatusCode != http.StatusOK {
+        span.SetStatus(codes.Error, outcome)
+    }
+    span.End()
 
     // If the accounting service doesn't return 200 OK, abort the request.
     if resp.StatusCode != http.StatusOK {
@@ -138,3 +160,42 @@ func DynamicAccountingMiddleware(c *gin.Context) {
     // If the accounting service returns OK, continue processing.
     c.Next()
 }
+
+// accountingClient propagates the trace context to the accounting service.
+var accountingClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// AccountingRequest sends a JSON request to path on the accounting service
+// within ctx, on behalf of the caller of c. The caller's identity is signed
+// with IDENTITY_SECRET when it is set, so the accounting service can trust
+// it.
+func AccountingRequest(ctx context.Context, c *gin.Context, method, path string, payload []byte) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, method, config.AccountingEndpoint+path, bytes.NewReader(payload))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    requestID := logging.RequestID(ctx)
+    if requestID != "" {
+        req.Header.Set(logging.HeaderRequestID, requestID)
+    }
+    if config.IdentitySecret != "" {
+        who, _ := caller.FromContext(c)
+        proxy.SetIdentityHeaders(req.Header, who.User, who.Role, who.Tenant, requestID)
+    }
+    return accountingClient.Do(req)
+}
+
+// chargeOutcome maps the accounting service's answer to a charge outcome.
+func chargeOutcome(status int) string {
+    switch status {
+    case http.StatusOK:
+        return metrics.ChargeOK
+    case http.StatusPaymentRequired:
+        return metrics.ChargeInsufficientBalance
+    case http.StatusForbidden:
+        return metrics.ChargeNoRule
+    case http.StatusNotFound:
+        return metrics.ChargeUnknownUser
+    }
+    return metrics.ChargeError
+}
@@ -4,21 +4,39 @@ import (
 	"net/http"
 	"strings"
 
+	"auth_service/caller"
 	"auth_service/config"
+	"auth_service/metrics"
+	"auth_service/proxy"
+	"auth_service/tracing"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AuthMiddleware validates the JWT token on protected routes.
 func AuthMiddleware(c *gin.Context) {
-    authHeader := c.GetHeader("Authorization")
-    if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-        c.Abort()
+    _, span := tracing.Start(c.Request.Context(), "auth.jwt")
+    claims, ok := verifyToken(c, span)
+    span.End()
+    if !ok {
         return
     }
-    tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
+
+    c.Set(caller.ClaimsKey, claims)
+    c.Next()
+}
+
+// verifyToken returns the claims of the request's JWT. Otherwise it answers
+// 401 and returns false.
+func verifyToken(c *gin.Context, span trace.Span) (jwt.MapClaims, bool) {
+    tokenStr := bearerToken(c)
+    if tokenStr == "" {
+        rejectCredentials(c, span, metrics.TokenMissing, "Unauthorized")
+        return nil, false
+    }
 
     token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
         // Ensure the signing method is HMAC.
@@ -28,18 +46,67 @@ func AuthMiddleware(c *gin.Context) {
         return []byte(config.SecretKey), nil
     })
     if err != nil || !token.Valid {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-        c.Abort()
-        return
+        rejectCredentials(c, span, metrics.TokenInvalid, "Invalid or expired token")
+        return nil, false
     }
 
     claims, ok := token.Claims.(jwt.MapClaims)
     if !ok {
-        c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-        c.Abort()
-        return
+        rejectCredentials(c, span, metrics.TokenBadClaims, "Invalid token claims")
+        return nil, false
     }
+    return claims, true
+}
 
-    c.Set("claims", claims)
-    c.Next()
+// rejectCredentials answers 401 with message and records reason in the
+// metrics and the span.
+func rejectCredentials(c *gin.Context, span trace.Span, reason, message string) {
+    metrics.AuthFailure(reason)
+    span.SetStatus(codes.Error, reason)
+    c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+    c.Abort()
+}
+
+// bearerToken returns the JWT of the request. Browsers cannot set headers on
+// WebSocket and EventSource requests, so those may instead pass it in the
+// access_token query parameter or, for WebSockets, as the subprotocol offered
+// after "bearer". The token is removed from the request before it is proxied.
+func bearerToken(c *gin.Context) string {
+    if authHeader := c.GetHeader("Authorization"); strings.HasPrefix(authHeader, "Bearer ") {
+        return strings.TrimPrefix(authHeader, "Bearer ")
+    }
+    if !proxy.IsWebSocketRequest(c.Request) && !proxy.IsEventStreamRequest(c.Request) {
+        return ""
+    }
+
+    query := c.Request.URL.Query()
+    if token := query.Get("access_token"); token != "" {
+        query.Del("access_token")
+        c.Request.URL.RawQuery = query.Encode()
+        return token
+    }
+
+    if !proxy.IsWebSocketRequest(c.Request) {
+        return ""
+    }
+    var protocols []string
+    for _, v := range c.Request.Header.Values("Sec-WebSocket-Protocol") {
+        for _, p := range strings.Split(v, ",") {
+            protocols = append(protocols, strings.TrimSpace(p))
+        }
+    }
+    for i, p := range protocols {
+        if p == proxy.TokenSubprotocol && i+1 < len(protocols) {
+            token := protocols[i+1]
+            remaining := append(protocols[:i:i], protocols[i+2:]...)
+            if len(remaining) > 0 {
+                c.Request.Header.Set("Sec-WebSocket-Protocol", strings.Join(remaining, ", "))
+            } else {
+                c.Request.Header.Del("Sec-WebSocket-Protocol")
+            }
+            c.Set(proxy.ContextTokenSubprotocol, true)
+            return token
+        }
+    }
+    return ""
 }
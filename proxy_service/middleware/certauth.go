@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"crypto/x509"
+	"log/slog"
+	"net/http"
+
+	"auth_service/caller"
+	"auth_service/database"
+	"auth_service/metrics"
+	"auth_service/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuthMethodClientCert is the "auth" claim of callers authenticated by a
+// client certificate.
+const AuthMethodClientCert = "client_cert"
+
+// ClientCertAuthMiddleware authenticates callers of routes that accept
+// client certificates. Requests carrying an Authorization header go through
+// AuthMiddleware; otherwise the certificate verified by the TLS listener is
+// mapped to the user whose CertSubject matches one of its names, and refused
+// when the names match several users. The user's claims are set as if they
+// had presented a token.
+func ClientCertAuthMiddleware(c *gin.Context) {
+	cert := verifiedClientCert(c.Request)
+	if cert == nil || c.GetHeader("Authorization") != "" {
+		AuthMiddleware(c)
+		return
+	}
+
+	ctx, span := tracing.Start(c.Request.Context(), "auth.client_cert")
+	// Each name is mapped to at most one user, but the names of a single
+	// certificate may be mapped to different users; such a certificate
+	// identifies nobody.
+	var users []database.User
+	err := database.DB.WithContext(ctx).
+		Preload("Role").
+		Where("cert_subject IN ?", CertIdentities(cert)).
+		Limit(2).
+		Find(&users).Error
+	if err != nil || len(users) == 0 {
+		rejectCredentials(c, span, metrics.CertNotMapped, "Client certificate is not mapped to a user")
+		span.End()
+		return
+	}
+	if len(users) > 1 {
+		slog.WarnContext(ctx, "Client certificate maps to several users", "subject", cert.Subject.String(), "users", []string{users[0].Username, users[1].Username})
+		rejectCredentials(c, span, metrics.CertAmbiguous, "Client certificate is mapped to several users")
+		span.End()
+		return
+	}
+	user := users[0]
+	span.End()
+
+	c.Set(caller.ClaimsKey, jwt.MapClaims{
+		"user": user.Username,
+		"role": user.Role.Name,
+		"auth": AuthMethodClientCert,
+	})
+	c.Next()
+}
+
+// verifiedClientCert returns the leaf client certificate of req if the TLS
+// listener verified it against the client CA, or nil.
+func verifiedClientCert(req *http.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// CertIdentities returns the names a certificate can be mapped to a user
+// by: its subject common name and its DNS, email and URI SANs.
+func CertIdentities(cert *x509.Certificate) []string {
+	var ids []string
+	if cert.Subject.CommonName != "" {
+		ids = append(ids, cert.Subject.CommonName)
+	}
+	ids = append(ids, cert.DNSNames...)
+	ids = append(ids, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
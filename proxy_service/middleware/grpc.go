@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"auth_service/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gRPC status codes used for gateway errors.
+const (
+	grpcUnknown            = 2
+	grpcInvalidArgument    = 3
+	grpcDeadlineExceeded   = 4
+	grpcPermissionDenied   = 7
+	grpcResourceExhausted  = 8
+	grpcFailedPrecondition = 9
+	grpcUnimplemented      = 12
+	grpcInternal           = 13
+	grpcUnavailable        = 14
+	grpcUnauthenticated    = 16
+)
+
+// grpcStatus maps the HTTP status of a gateway error to a gRPC status code.
+func grpcStatus(httpStatus int) int {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return grpcInvalidArgument
+	case http.StatusUnauthorized:
+		return grpcUnauthenticated
+	case http.StatusPaymentRequired:
+		return grpcFailedPrecondition
+	case http.StatusForbidden:
+		return grpcPermissionDenied
+	case http.StatusNotFound:
+		return grpcUnimplemented
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return grpcResourceExhausted
+	case http.StatusInternalServerError:
+		return grpcInternal
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return grpcUnavailable
+	case http.StatusGatewayTimeout:
+		return grpcDeadlineExceeded
+	}
+	return grpcUnknown
+}
+
+// grpcErrorWriter holds back JSON error bodies so they can be answered as
+// gRPC statuses instead.
+type grpcErrorWriter struct {
+	gin.ResponseWriter
+	intercepted bool
+	body        bytes.Buffer
+}
+
+func (w *grpcErrorWriter) intercepts() bool {
+	if !w.intercepted && !w.Written() && w.Status() >= 400 &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.intercepted = true
+	}
+	return w.intercepted
+}
+
+func (w *grpcErrorWriter) Write(b []byte) (int, error) {
+	if w.intercepts() {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *grpcErrorWriter) WriteString(s string) (int, error) {
+	if w.intercepts() {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// GRPCErrorMiddleware answers errors raised by the gateway itself on gRPC
+// calls (authentication, rate limits, quotas, accounting, upstream failures)
+// as gRPC statuses, since gRPC clients ignore HTTP error bodies. Other
+// requests pass through untouched.
+func GRPCErrorMiddleware(c *gin.Context) {
+	if !proxy.IsGRPCRequest(c.Request) {
+		c.Next()
+		return
+	}
+
+	w := &grpcErrorWriter{ResponseWriter: c.Writer}
+	c.Writer = w
+	c.Next()
+	c.Writer = w.ResponseWriter
+
+	if !w.intercepted {
+		return
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(w.body.Bytes(), &body)
+	if body.Error == "" {
+		body.Error = http.StatusText(w.Status())
+	}
+
+	// A trailers-only response: the status travels in the headers.
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", "application/grpc")
+	header.Set("Grpc-Status", strconv.Itoa(grpcStatus(w.Status())))
+	header.Set("Grpc-Message", url.PathEscape(body.Error))
+	w.ResponseWriter.WriteHeader(http.StatusOK)
+	w.ResponseWriter.WriteHeaderNow()
+}
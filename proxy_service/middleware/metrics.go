@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"time"
+
+	"auth_service/metrics"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetricsMiddleware records the count and latency of every request under
+// its route pattern, so /users/:username is one series however many users
+// there are. Unmatched requests share a single series.
+func MetricsMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+	metrics.ObserveRequest(c.FullPath(), c.Request.Method, c.Writer.Status(), time.Since(start))
+}
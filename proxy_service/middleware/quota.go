@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"auth_service/caller"
+	"auth_service/proxy"
+	"auth_service/quota"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QuotaMiddleware enforces the usage quotas defined for the custom endpoint
+// at path. It counts the call before accounting runs and gives it back when
+// a later handler aborts the request, e.g. when the charge is rejected, or
+// when the gateway itself answers with a 5xx because no upstream response
+// could be served. Callers over a quota get 429 with the exhausted quota in
+// the body.
+func QuotaMiddleware(path string) gin.HandlerFunc {
+	endpoint := quota.EndpointKey(path)
+
+	return func(c *gin.Context) {
+		who, _ := caller.FromContext(c)
+		username, role := who.User, who.Role
+		if username == "" {
+			c.Next()
+			return
+		}
+
+		quotas, err := quota.Applicable(c.Request.Context(), endpoint, username, role)
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to look up quotas", "user", username, "endpoint", endpoint, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check quota"})
+			c.Abort()
+			return
+		}
+		if len(quotas) == 0 {
+			c.Next()
+			return
+		}
+
+		now := time.Now()
+		statuses, err := quota.Consume(c.Request.Context(), quotas, username, now)
+		var exceeded *quota.ExceededError
+		if errors.As(err, &exceeded) {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(time.Until(exceeded.Status.ResetsAt))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Quota exceeded", "quota": exceeded.Status})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(c.Request.Context(), "Failed to count quota usage", "user", username, "endpoint", endpoint, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check quota"})
+			c.Abort()
+			return
+		}
+
+		// Report the quota closest to running out.
+		tightest := statuses[0]
+		for _, s := range statuses[1:] {
+			if s.Remaining < tightest.Remaining {
+				tightest = s
+			}
+		}
+		c.Header("X-Quota-Limit", strconv.FormatInt(tightest.Limit, 10))
+		c.Header("X-Quota-Remaining", strconv.FormatInt(tightest.Remaining, 10))
+		c.Header("X-Quota-Reset", tightest.ResetsAt.UTC().Format(http.TimeFormat))
+
+		c.Next()
+
+		if refundable(c) {
+			if err := quota.Refund(context.Background(), quotas, username, now); err != nil {
+				slog.ErrorContext(c.Request.Context(), "Failed to refund quota", "user", username, "endpoint", endpoint, "error", err)
+			}
+		}
+	}
+}
+
+// refundable reports whether the call counted against the quotas should be
+// given back: a later handler refused it, or the gateway failed to get an
+// answer from the upstream. Upstream 5xx answers still count.
+func refundable(c *gin.Context) bool {
+	if c.IsAborted() {
+		return true
+	}
+	return c.Writer.Status() >= http.StatusInternalServerError && c.GetBool(proxy.ContextGatewayError)
+}
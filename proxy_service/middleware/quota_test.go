@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"auth_service/proxy"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestQuotaRefundable(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name         string
+		status       int
+		aborted      bool
+		gatewayError bool
+		want         bool
+	}{
+		{"served", http.StatusOK, false, false, false},
+		{"charge refused", http.StatusPaymentRequired, true, false, true},
+		{"upstream unreachable", http.StatusBadGateway, false, true, true},
+		{"upstream timed out", http.StatusGatewayTimeout, false, true, true},
+		{"upstream answered 5xx", http.StatusInternalServerError, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Status(tt.status)
+			if tt.aborted {
+				c.Abort()
+			}
+			if tt.gatewayError {
+				c.Set(proxy.ContextGatewayError, true)
+			}
+			if got := refundable(c); got != tt.want {
+				t.Errorf("refundable = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"auth_service/caller"
+	"auth_service/config"
+	"auth_service/database"
+	"auth_service/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rate-limit keys.
+const (
+	RateLimitByUser   = "user"
+	RateLimitByRole   = "role"
+	RateLimitByAPIKey = "api_key"
+	RateLimitByIP     = "ip"
+	RateLimitByRoute  = "route"
+)
+
+const defaultRateLimitPeriod = time.Minute
+
+var (
+	rateLimitStore     ratelimit.Store
+	rateLimitStoreOnce sync.Once
+)
+
+// limiterStore returns the store selected by config.RateLimitStore.
+func limiterStore() ratelimit.Store {
+	rateLimitStoreOnce.Do(func() {
+		if config.RateLimitStore == "postgres" {
+			rateLimitStore = ratelimit.NewPostgresStore(database.DB)
+		} else {
+			rateLimitStore = ratelimit.NewMemoryStore()
+		}
+	})
+	return rateLimitStore
+}
+
+type routeLimit struct {
+	limit ratelimit.Limit
+	key   string
+}
+
+// resolveRateLimit applies defaults to cfg. It returns nil when cfg disables limiting.
+func resolveRateLimit(cfg database.RateLimit) (*routeLimit, error) {
+	if cfg.Requests <= 0 {
+		return nil, nil
+	}
+
+	rl := &routeLimit{
+		limit: ratelimit.Limit{
+			Algorithm: cfg.Algorithm,
+			Requests:  cfg.Requests,
+			Period:    cfg.Period.Or(defaultRateLimitPeriod),
+			Burst:     cfg.Burst,
+		},
+		key: cfg.Key,
+	}
+	if rl.limit.Algorithm == "" {
+		rl.limit.Algorithm = ratelimit.AlgorithmTokenBucket
+	}
+	if rl.key == "" {
+		rl.key = RateLimitByUser
+	}
+
+	switch rl.key {
+	case RateLimitByUser, RateLimitByRole, RateLimitByAPIKey, RateLimitByIP, RateLimitByRoute:
+	default:
+		return nil, fmt.Errorf("unknown rate limit key %q", rl.key)
+	}
+	return rl, rl.limit.Validate()
+}
+
+// RateLimitMiddleware limits requests to the route whose counters are named
+// by scope. Callers whose role has an entry in roleLimits use that limit
+// instead of limit. It returns a nil handler when no limit is configured.
+// Requests over the limit get 429 with RateLimit-* and Retry-After headers.
+func RateLimitMiddleware(scope string, limit database.RateLimit, roleLimits map[string]database.RateLimit) (gin.HandlerFunc, error) {
+	defaultLimit, err := resolveRateLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	byRole := make(map[string]*routeLimit, len(roleLimits))
+	for role, cfg := range roleLimits {
+		rl, err := resolveRateLimit(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("role %s: %w", role, err)
+		}
+		byRole[role] = rl
+	}
+
+	if defaultLimit == nil && len(roleLimits) == 0 {
+		return nil, nil
+	}
+
+	return func(c *gin.Context) {
+		who, _ := caller.FromContext(c)
+
+		rl := defaultLimit
+		if roleLimit, ok := byRole[who.Role]; ok {
+			// A role entry without requests exempts the role.
+			rl = roleLimit
+		}
+		if rl == nil {
+			c.Next()
+			return
+		}
+
+		key := "rl:" + scope + ":" + rl.key + ":" + rateLimitSubject(c, rl.key, who.User, who.Role)
+		decision, err := limiterStore().Allow(c.Request.Context(), key, rl.limit)
+		if err != nil {
+			// Fail open: an unavailable store must not take the gateway down.
+			slog.WarnContext(c.Request.Context(), "Rate limit store error, letting the request through", "key", key, "error", err)
+			c.Next()
+			return
+		}
+
+		c.Header("RateLimit-Limit", strconv.Itoa(decision.Limit))
+		c.Header("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+		c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rl.limit.Requests, ceilSeconds(rl.limit.Period)))
+
+		if !decision.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}, nil
+}
+
+// rateLimitSubject returns who a request is counted against. Missing
+// identities fall back to the client IP.
+func rateLimitSubject(c *gin.Context, key, username, role string) string {
+	switch key {
+	case RateLimitByRoute:
+		return "all"
+	case RateLimitByUser:
+		if username != "" {
+			return username
+		}
+	case RateLimitByRole:
+		if role != "" {
+			return role
+		}
+	case RateLimitByAPIKey:
+		if apiKey := c.GetHeader("X-API-Key"); apiKey != "" {
+			// Keep raw keys out of the shared store.
+			sum := sha256.Sum256([]byte(apiKey))
+			return hex.EncodeToString(sum[:16])
+		}
+	}
+	return "ip:" + c.ClientIP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
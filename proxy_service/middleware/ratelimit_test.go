@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth_service/caller"
+	"auth_service/database"
+	"auth_service/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func init() {
+	// Tests use a fresh in-process store whatever config.RateLimitStore says.
+	rateLimitStoreOnce.Do(func() { rateLimitStore = ratelimit.NewMemoryStore() })
+}
+
+// rateLimitedEngine serves /limited behind RateLimitMiddleware for the given
+// user, or anonymously when user is empty.
+func rateLimitedEngine(t *testing.T, scope string, limit database.RateLimit, roleLimits map[string]database.RateLimit, user, role string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	rl, err := RateLimitMiddleware(scope, limit, roleLimits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine := gin.New()
+	engine.GET("/limited", func(c *gin.Context) {
+		if user != "" {
+			c.Set(caller.ClaimsKey, jwt.MapClaims{"user": user, "role": role})
+		}
+	}, rl, func(c *gin.Context) { c.Status(http.StatusOK) })
+	return engine
+}
+
+func get(engine *gin.Engine) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/limited", nil))
+	return w
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limit := database.RateLimit{Requests: 2, Period: database.Duration(time.Hour)}
+	engine := rateLimitedEngine(t, t.Name(), limit, nil, "alice", "user")
+
+	for i, remaining := range []string{"1", "0"} {
+		w := get(engine)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+		if got := w.Header().Get("RateLimit-Remaining"); got != remaining {
+			t.Fatalf("request %d: RateLimit-Remaining %q, want %q", i, got, remaining)
+		}
+	}
+
+	w := get(engine)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit: status %d, want 429", w.Code)
+	}
+	want := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "3600",
+		"RateLimit-Policy":    "2;w=3600",
+		"Retry-After":         "1800",
+	}
+	for name, value := range want {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	// Counters are per user.
+	if w := get(rateLimitedEngine(t, t.Name(), limit, nil, "bob", "user")); w.Code != http.StatusOK {
+		t.Fatalf("another user: status %d", w.Code)
+	}
+}
+
+func TestRateLimitMiddlewareRoles(t *testing.T) {
+	limit := database.RateLimit{Requests: 1, Period: database.Duration(time.Hour)}
+	roleLimits := map[string]database.RateLimit{
+		"admin": {}, // Exempt
+		"pro":   {Requests: 3, Period: database.Duration(time.Hour)},
+	}
+
+	tests := []struct {
+		role    string
+		allowed int
+	}{
+		{"user", 1},
+		{"pro", 3},
+		{"admin", 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			engine := rateLimitedEngine(t, t.Name(), limit, roleLimits, "carol", tt.role)
+			for i := 0; i < tt.allowed; i++ {
+				if w := get(engine); w.Code != http.StatusOK {
+					t.Fatalf("request %d: status %d", i, w.Code)
+				}
+			}
+			if tt.role == "admin" {
+				return
+			}
+			if w := get(engine); w.Code != http.StatusTooManyRequests {
+				t.Fatalf("request over the role limit: status %d, want 429", w.Code)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareDisabled(t *testing.T) {
+	rl, err := RateLimitMiddleware(t.Name(), database.RateLimit{}, nil)
+	if err != nil || rl != nil {
+		t.Fatalf("got %v, %v; want no handler", rl != nil, err)
+	}
+	if _, err := RateLimitMiddleware(t.Name(), database.RateLimit{Requests: 1, Key: "nope"}, nil); err == nil {
+		t.Fatal("unknown key accepted")
+	}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load-balancing strategies selectable per custom endpoint.
+const (
+	StrategyRandom             = "random"
+	StrategyRoundRobin         = "round_robin"
+	StrategyWeightedRoundRobin = "weighted_round_robin"
+	StrategyLeastConnections   = "least_connections"
+	StrategyConsistentHash     = "consistent_hash"
+	StrategyPowerOfTwoChoices  = "p2c"
+)
+
+// Target is a single upstream address of a route.
+type Target struct {
+	URL    *url.URL
+	Weight int
+
+	// active is the number of in-flight requests sent to this target.
+	active int64
+
+	route     string // Path of the route the target serves, for logs
+	health    targetHealth
+	breaker   *breaker
+	transport http.RoundTripper // Shared by the route's targets unless TLS settings apply
+}
+
+// NewTarget parses rawURL into a Target with the given weight.
+// Weights below 1 are treated as 1.
+func NewTarget(rawURL string, weight int) (*Target, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	return &Target{URL: u, Weight: weight}, nil
+}
+
+// Active returns the number of in-flight requests to the target.
+func (t *Target) Active() int64 {
+	return atomic.LoadInt64(&t.active)
+}
+
+func (t *Target) acquire() {
+	atomic.AddInt64(&t.active, 1)
+}
+
+func (t *Target) release() {
+	atomic.AddInt64(&t.active, -1)
+}
+
+// Balancer chooses one of the candidate targets for a request.
+// Candidates are always a non-empty subset of the targets the balancer was
+// built with. Key is only used by hashing strategies.
+type Balancer interface {
+	Next(candidates []*Target, key string) *Target
+}
+
+// NewBalancer returns the balancer implementing strategy over targets.
+// An empty strategy selects random balancing.
+func NewBalancer(strategy string, targets []*Target) (Balancer, error) {
+	switch strategy {
+	case "", StrategyRandom:
+		return newRandomBalancer(), nil
+	case StrategyRoundRobin:
+		return &roundRobinBalancer{}, nil
+	case StrategyWeightedRoundRobin:
+		return &weightedRoundRobinBalancer{current: make(map[*Target]int)}, nil
+	case StrategyLeastConnections:
+		return &leastConnectionsBalancer{}, nil
+	case StrategyConsistentHash:
+		return newConsistentHashBalancer(targets), nil
+	case StrategyPowerOfTwoChoices:
+		return &powerOfTwoBalancer{random: newRandomBalancer()}, nil
+	default:
+		return nil, fmt.Errorf("unknown load-balancing strategy %q", strategy)
+	}
+}
+
+// randomBalancer picks a uniformly random candidate.
+type randomBalancer struct {
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+func newRandomBalancer() *randomBalancer {
+	return &randomBalancer{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *randomBalancer) intn(n int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rng.Intn(n)
+}
+
+func (b *randomBalancer) Next(candidates []*Target, _ string) *Target {
+	return candidates[b.intn(len(candidates))]
+}
+
+// roundRobinBalancer cycles through the candidates in order.
+type roundRobinBalancer struct {
+	counter uint64
+}
+
+func (b *roundRobinBalancer) Next(candidates []*Target, _ string) *Target {
+	n := atomic.AddUint64(&b.counter, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weightedRoundRobinBalancer implements smooth weighted round robin: every
+// pick raises each candidate by its weight and lowers the winner by the total,
+// which spreads heavier targets evenly instead of in bursts.
+type weightedRoundRobinBalancer struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+func (b *weightedRoundRobinBalancer) Next(candidates []*Target, _ string) *Target {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best *Target
+	total := 0
+	for _, t := range candidates {
+		b.current[t] += t.Weight
+		total += t.Weight
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+// leastConnectionsBalancer picks the candidate with the fewest in-flight
+// requests, preferring the earliest one on ties.
+type leastConnectionsBalancer struct{}
+
+func (leastConnectionsBalancer) Next(candidates []*Target, _ string) *Target {
+	best := candidates[0]
+	for _, t := range candidates[1:] {
+		if t.Active() < best.Active() {
+			best = t
+		}
+	}
+	return best
+}
+
+// powerOfTwoBalancer samples two random candidates and keeps the less loaded.
+type powerOfTwoBalancer struct {
+	random *randomBalancer
+}
+
+func (b *powerOfTwoBalancer) Next(candidates []*Target, _ string) *Target {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	i := b.random.intn(len(candidates))
+	j := b.random.intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+	if candidates[j].Active() < candidates[i].Active() {
+		return candidates[j]
+	}
+	return candidates[i]
+}
+
+// hashReplicas is the number of virtual nodes placed on the ring per unit
+// of target weight.
+const hashReplicas = 100
+
+type ringNode struct {
+	hash   uint32
+	target *Target
+}
+
+// consistentHashBalancer maps keys onto a hash ring so the same key keeps
+// reaching the same target while the target set is stable.
+type consistentHashBalancer struct {
+	ring   []ringNode
+	random *randomBalancer
+}
+
+func newConsistentHashBalancer(targets []*Target) *consistentHashBalancer {
+	b := &consistentHashBalancer{random: newRandomBalancer()}
+	for _, t := range targets {
+		for i := 0; i < hashReplicas*t.Weight; i++ {
+			h := crc32.ChecksumIEEE([]byte(t.URL.String() + "#" + strconv.Itoa(i)))
+			b.ring = append(b.ring, ringNode{hash: h, target: t})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+	return b
+}
+
+func (b *consistentHashBalancer) Next(candidates []*Target, key string) *Target {
+	// Without a key there is nothing to be sticky on.
+	if key == "" || len(b.ring) == 0 {
+		return b.random.Next(candidates, key)
+	}
+
+	allowed := make(map[*Target]bool, len(candidates))
+	for _, t := range candidates {
+		allowed[t] = true
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+
+	// Walk clockwise until we meet a target that is still a candidate.
+	for i := 0; i < len(b.ring); i++ {
+		node := b.ring[(start+i)%len(b.ring)]
+		if allowed[node.target] {
+			return node.target
+		}
+	}
+	return b.random.Next(candidates, key)
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+)
+
+func newTargets(t *testing.T, weights ...int) []*Target {
+	t.Helper()
+	targets := make([]*Target, len(weights))
+	for i, w := range weights {
+		target, err := NewTarget(fmt.Sprintf("http://upstream-%d:8080", i), w)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets[i] = target
+	}
+	return targets
+}
+
+func TestWeightedRoundRobinShare(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []int
+	}{
+		{"equal", []int{1, 1, 1}},
+		{"weighted", []int{5, 1, 1}},
+		{"uneven", []int{3, 2}},
+		{"zero weight treated as one", []int{0, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := newTargets(t, tt.weights...)
+			b, err := NewBalancer(StrategyWeightedRoundRobin, targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			total := 0
+			for _, target := range targets {
+				total += target.Weight
+			}
+			const cycles = 50
+			picks := make(map[*Target]int)
+			for i := 0; i < cycles*total; i++ {
+				picks[b.Next(targets, "")]++
+			}
+
+			// Smooth WRR is exact over whole cycles.
+			for i, target := range targets {
+				if want := cycles * target.Weight; picks[target] != want {
+					t.Errorf("target %d: got %d picks, want %d", i, picks[target], want)
+				}
+			}
+		})
+	}
+}
+
+func TestWeightedRoundRobinIsSmooth(t *testing.T) {
+	targets := newTargets(t, 5, 1, 1)
+	b, _ := NewBalancer(StrategyWeightedRoundRobin, targets)
+
+	// The heavy target never gets more than its share in a row.
+	var got []int
+	for i := 0; i < 7; i++ {
+		next := b.Next(targets, "")
+		for j, target := range targets {
+			if target == next {
+				got = append(got, j)
+			}
+		}
+	}
+	want := []int{0, 0, 1, 0, 2, 0, 0}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got sequence %v, want %v", got, want)
+	}
+}
+
+func TestLeastConnectionsChoice(t *testing.T) {
+	tests := []struct {
+		name   string
+		active []int64
+		want   int
+	}{
+		{"fewest in flight", []int64{3, 1, 2}, 1},
+		{"ties go to the first", []int64{2, 1, 1}, 1},
+		{"all idle", []int64{0, 0, 0}, 0},
+		{"single target", []int64{7}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := newTargets(t, make([]int, len(tt.active))...)
+			for i, n := range tt.active {
+				targets[i].active = n
+			}
+			b, _ := NewBalancer(StrategyLeastConnections, targets)
+			if got := b.Next(targets, ""); got != targets[tt.want] {
+				t.Errorf("got %s, want %s", got.URL, targets[tt.want].URL)
+			}
+		})
+	}
+}
+
+func TestLeastConnectionsFollowsLoad(t *testing.T) {
+	targets := newTargets(t, 1, 1)
+	b, _ := NewBalancer(StrategyLeastConnections, targets)
+
+	first := b.Next(targets, "")
+	first.acquire()
+	if second := b.Next(targets, ""); second == first {
+		t.Fatalf("picked the busy target %s again", first.URL)
+	}
+	first.release()
+}
+
+func TestConsistentHashStickiness(t *testing.T) {
+	targets := newTargets(t, 1, 1, 1, 1)
+	b, _ := NewBalancer(StrategyConsistentHash, targets)
+
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("user-%d", i)
+	}
+
+	assigned := make(map[string]*Target, len(keys))
+	counts := make(map[*Target]int)
+	for _, key := range keys {
+		assigned[key] = b.Next(targets, key)
+		counts[assigned[key]]++
+	}
+
+	// Every key keeps its target.
+	for i := 0; i < 3; i++ {
+		for _, key := range keys {
+			if got := b.Next(targets, key); got != assigned[key] {
+				t.Fatalf("key %s moved from %s to %s", key, assigned[key].URL, got.URL)
+			}
+		}
+	}
+
+	// The ring spreads keys over all targets.
+	for i, target := range targets {
+		if counts[target] < len(keys)/len(targets)/2 {
+			t.Errorf("target %d got only %d of %d keys", i, counts[target], len(keys))
+		}
+	}
+}
+
+func TestConsistentHashRemapping(t *testing.T) {
+	targets := newTargets(t, 1, 1, 1, 1)
+	b, _ := NewBalancer(StrategyConsistentHash, targets)
+
+	tests := []struct {
+		name    string
+		removed int
+	}{
+		{"first target", 0},
+		{"middle target", 2},
+		{"last target", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			removed := targets[tt.removed]
+			var remaining []*Target
+			for _, target := range targets {
+				if target != removed {
+					remaining = append(remaining, target)
+				}
+			}
+
+			// Ejecting the target from the candidates and rebuilding the
+			// ring without it must agree.
+			rebuilt, _ := NewBalancer(StrategyConsistentHash, remaining)
+
+			moved := 0
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("user-%d", i)
+				before := b.Next(targets, key)
+				after := b.Next(remaining, key)
+				if got := rebuilt.Next(remaining, key); got != after {
+					t.Fatalf("key %s maps to %s on the rebuilt ring but %s after ejection", key, got.URL, after.URL)
+				}
+				switch {
+				case after == removed:
+					t.Fatalf("key %s still maps to the removed target", key)
+				case before != removed && after != before:
+					t.Fatalf("key %s moved from %s to %s though its target remains", key, before.URL, after.URL)
+				case before == removed:
+					moved++
+				}
+			}
+			if moved == 0 {
+				t.Error("no key was mapped to the removed target")
+			}
+		})
+	}
+}
+
+func TestConsistentHashWithoutKey(t *testing.T) {
+	targets := newTargets(t, 1, 1)
+	b, _ := NewBalancer(StrategyConsistentHash, targets)
+
+	for i := 0; i < 20; i++ {
+		if got := b.Next(targets[1:], ""); got != targets[1] {
+			t.Fatalf("got %s, not a candidate", got.URL)
+		}
+	}
+}
+
+func TestUnknownStrategy(t *testing.T) {
+	if _, err := NewBalancer("fastest", newTargets(t, 1)); err == nil {
+		t.Error("unknown strategy was accepted")
+	}
+}
@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"auth_service/database"
+	"auth_service/metrics"
+)
+
+// Circuit-breaker defaults used when the route leaves a value unset.
+const (
+	defaultBreakerMinRequests      = 10
+	defaultBreakerWindow           = 10 * time.Second
+	defaultBreakerOpenTimeout      = 30 * time.Second
+	defaultBreakerHalfOpenRequests = 1
+)
+
+// BreakerState is the state of a target's circuit breaker.
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// outcome is how a proxied request ended, from the target's point of view.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored is used when the client went away before the target answered.
+	outcomeIgnored
+)
+
+// breaker is a closed/open/half-open circuit breaker guarding one target.
+// While closed it counts requests in fixed windows and opens once the failure
+// ratio is exceeded; after OpenTimeout it lets trial requests through and
+// closes again when enough of them succeed.
+type breaker struct {
+	mu sync.Mutex
+
+	route  string // Labels of the breaker's metrics
+	target string
+
+	failureRatio     float64
+	minRequests      int
+	window           time.Duration
+	openTimeout      time.Duration
+	halfOpenRequests int
+
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	trials      int // trial requests in flight while half-open
+	successes   int // successful trial requests while half-open
+	opens       int // number of times the circuit has opened
+}
+
+// newBreaker returns the breaker guarding target of route, or nil when cfg
+// disables the breaker.
+func newBreaker(cfg database.CircuitBreaker, route, target string) *breaker {
+	if cfg.FailureRatio <= 0 {
+		return nil
+	}
+
+	b := &breaker{
+		route:            route,
+		target:           target,
+		failureRatio:     cfg.FailureRatio,
+		minRequests:      cfg.MinRequests,
+		window:           cfg.Window.Or(defaultBreakerWindow),
+		openTimeout:      cfg.OpenTimeout.Or(defaultBreakerOpenTimeout),
+		halfOpenRequests: cfg.HalfOpenRequests,
+	}
+	if b.minRequests <= 0 {
+		b.minRequests = defaultBreakerMinRequests
+	}
+	if b.halfOpenRequests <= 0 {
+		b.halfOpenRequests = defaultBreakerHalfOpenRequests
+	}
+	metrics.CircuitState(route, target, int(BreakerClosed))
+	return b
+}
+
+// setState moves the breaker to state and exports it. Callers must hold b.mu.
+func (b *breaker) setState(state BreakerState) {
+	b.state = state
+	metrics.CircuitState(b.route, b.target, int(state))
+}
+
+// currentState returns the state, taking an elapsed open timeout into account.
+// The move to half-open happens here, so it is exported on the first check
+// after the timeout. Callers must hold b.mu.
+func (b *breaker) currentState(now time.Time) BreakerState {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.openTimeout {
+		b.setState(BreakerHalfOpen)
+		b.trials = 0
+		b.successes = 0
+	}
+	return b.state
+}
+
+// ready reports whether a request could be sent through the breaker now.
+func (b *breaker) ready(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState(now) {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		return b.trials < b.halfOpenRequests
+	default:
+		return true
+	}
+}
+
+// tryAcquire marks a request as sent through the breaker if it may be sent
+// now. While half-open the check and the taking of a trial slot happen under
+// one lock, so concurrent requests cannot exceed HalfOpenRequests.
+func (b *breaker) tryAcquire(now time.Time) bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState(now) {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.trials >= b.halfOpenRequests {
+			return false
+		}
+		b.trials++
+	}
+	return true
+}
+
+// cancel withdraws a request marked by tryAcquire that was never sent, so it
+// does not hold a half-open trial slot.
+func (b *breaker) cancel() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.currentState(time.Now()) == BreakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// record feeds the outcome of a request sent after tryAcquire. ctx is the
+// request's, for logging.
+func (b *breaker) record(ctx context.Context, o outcome) {
+	if b == nil {
+		return
+	}
+
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState(now) {
+	case BreakerHalfOpen:
+		if b.trials > 0 {
+			b.trials--
+		}
+		switch o {
+		case outcomeFailure:
+			b.trip(ctx, now)
+		case outcomeSuccess:
+			b.successes++
+			if b.successes >= b.halfOpenRequests {
+				b.setState(BreakerClosed)
+				b.windowStart = now
+				b.requests, b.failures = 0, 0
+				slog.InfoContext(ctx, "Circuit closed", "route", b.route, "target", b.target)
+			}
+		}
+
+	case BreakerClosed:
+		if o == outcomeIgnored {
+			return
+		}
+		if now.Sub(b.windowStart) >= b.window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+		b.requests++
+		if o == outcomeFailure {
+			b.failures++
+		}
+		if b.requests >= b.minRequests && float64(b.failures)/float64(b.requests) >= b.failureRatio {
+			b.trip(ctx, now)
+		}
+	}
+}
+
+// trip opens the circuit. Callers must hold b.mu.
+func (b *breaker) trip(ctx context.Context, now time.Time) {
+	b.setState(BreakerOpen)
+	b.openedAt = now
+	b.opens++
+	metrics.CircuitOpened(b.route, b.target)
+	slog.WarnContext(ctx, "Circuit opened", "route", b.route, "target", b.target, "requests", b.requests, "failures", b.failures, "open_timeout", b.openTimeout.String())
+}
+
+// snapshot returns the state and the number of times the circuit has opened.
+func (b *breaker) snapshot() (BreakerState, int) {
+	if b == nil {
+		return BreakerClosed, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState(time.Now()), b.opens
+}
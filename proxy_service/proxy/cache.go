@@ -0,0 +1,241 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"auth_service/cache"
+	"auth_service/caller"
+	"auth_service/config"
+	"auth_service/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Caller attributes a cache key may vary by.
+const (
+	CacheVaryUser = "user"
+	CacheVaryRole = "role"
+)
+
+const (
+	defaultCacheTTL           = time.Minute
+	defaultCacheMaxEntryBytes = 1 << 20
+)
+
+// cacheKeyKey is the request context key holding the cache key a response
+// should be stored under.
+type cacheKeyKey struct{}
+
+var (
+	cacheBackend     cache.Backend
+	cacheBackendOnce sync.Once
+)
+
+// responseCache returns the backend selected by config.CacheStore.
+func responseCache() cache.Backend {
+	cacheBackendOnce.Do(func() {
+		if config.CacheStore == "postgres" {
+			cacheBackend = cache.NewPostgresStore(database.DB)
+		} else {
+			cacheBackend = cache.NewLRU(config.CacheMaxBytes)
+		}
+	})
+	return cacheBackend
+}
+
+// PurgeCache removes the cached responses whose key starts with prefix. Keys
+// start with the client request path, so a route's path purges the route.
+func PurgeCache(ctx context.Context, prefix string) (int, error) {
+	return responseCache().Purge(ctx, prefix)
+}
+
+func validateCache(cfg database.ResponseCache) error {
+	for _, v := range cfg.VaryBy {
+		if v != CacheVaryUser && v != CacheVaryRole {
+			return fmt.Errorf("unknown cache vary %q", v)
+		}
+	}
+	return nil
+}
+
+// varies reports whether the route's cache keys include attr.
+func (r *Route) varies(attr string) bool {
+	for _, v := range r.cache.VaryBy {
+		if v == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheKey returns the key the request is cached under: its path and sorted
+// query, followed by the caller attributes the route varies by.
+func (r *Route) cacheKey(c *gin.Context) string {
+	key := c.Request.URL.Path
+	if query := c.Request.URL.Query().Encode(); query != "" {
+		key += "?" + query
+	}
+	// Endpoints sharing a path, e.g. per virtual host, must not share entries.
+	key += "#route=" + strconv.FormatUint(uint64(r.ID), 10)
+
+	who, _ := caller.FromContext(c)
+	if r.varies(CacheVaryUser) {
+		key += "#user=" + who.User
+	}
+	if r.varies(CacheVaryRole) {
+		key += "#role=" + who.Role
+	}
+	return key
+}
+
+// serveCached answers the request from the cache when possible. It returns
+// the key a fresh response should be stored under, or "" when the request
+// must not be cached, along with whether the request was answered.
+func (r *Route) serveCached(c *gin.Context) (string, bool) {
+	if !r.cache.Enabled || c.Request.Method != http.MethodGet || isStreamingRequest(c.Request) {
+		return "", false
+	}
+
+	directives := cacheControl(c.Request.Header)
+	if _, ok := directives["no-store"]; ok {
+		return "", false
+	}
+
+	key := r.cacheKey(c)
+	if _, ok := directives["no-cache"]; ok {
+		// Revalidate: skip the lookup but refresh the entry.
+		return key, false
+	}
+
+	entry, err := responseCache().Get(c.Request.Context(), key)
+	if err != nil {
+		slog.WarnContext(c.Request.Context(), "Cache lookup failed", "key", key, "error", err)
+		return key, false
+	}
+	if entry == nil {
+		return key, false
+	}
+
+	header := c.Writer.Header()
+	for k, values := range entry.Header {
+		header[k] = append([]string(nil), values...)
+	}
+	header.Set("Age", strconv.Itoa(int(time.Since(entry.StoredAt).Seconds())))
+	header.Set("X-Cache", "HIT")
+	c.Writer.WriteHeader(entry.Status)
+	c.Writer.Write(entry.Body)
+	return "", true
+}
+
+// storeResponse caches resp if the request was cacheable and the upstream
+// allows it.
+func (r *Route) storeResponse(resp *http.Response) error {
+	key, _ := resp.Request.Context().Value(cacheKeyKey{}).(string)
+	if key == "" {
+		return nil
+	}
+	resp.Header.Set("X-Cache", "MISS")
+
+	ttl, ok := r.cacheTTL(resp)
+	if !ok {
+		return nil
+	}
+
+	maxBytes := r.cache.MaxEntryBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultCacheMaxEntryBytes
+	}
+	if resp.ContentLength > maxBytes {
+		return nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	if int64(len(buf)) > maxBytes {
+		// Too large to cache: stream it on unchanged.
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(buf))
+
+	header := resp.Header.Clone()
+	header.Del("X-Cache")
+	now := time.Now()
+	entry := &cache.Entry{
+		Status:    resp.StatusCode,
+		Header:    header,
+		Body:      buf,
+		StoredAt:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := responseCache().Set(context.Background(), key, entry); err != nil {
+		slog.WarnContext(resp.Request.Context(), "Cache store failed", "route", r.Path, "key", key, "error", err)
+	}
+	return nil
+}
+
+// cacheTTL returns how long resp may be cached, and false when it may not be.
+func (r *Route) cacheTTL(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	for _, v := range resp.Header.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if field = strings.TrimSpace(field); field != "" && !strings.EqualFold(field, "Accept-Encoding") {
+				return 0, false
+			}
+		}
+	}
+
+	directives := cacheControl(resp.Header)
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["private"]; ok && !r.varies(CacheVaryUser) {
+		return 0, false
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if v, ok := directives[name]; ok {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return r.cache.TTL.Or(defaultCacheTTL), true
+}
+
+// cacheControl parses the Cache-Control directives of h.
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+	}
+	return directives
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auth_service/cache"
+	"auth_service/database"
+)
+
+func init() {
+	// Tests use an in-process cache whatever config.CacheStore says.
+	cacheBackendOnce.Do(func() { cacheBackend = cache.NewLRU(1 << 20) })
+}
+
+func TestCacheTTL(t *testing.T) {
+	route := &Route{cache: database.ResponseCache{Enabled: true, TTL: database.Duration(30 * time.Second)}}
+	perUser := &Route{cache: database.ResponseCache{Enabled: true, VaryBy: []string{CacheVaryUser}}}
+
+	tests := []struct {
+		name   string
+		r      *Route
+		status int
+		header http.Header
+		ttl    time.Duration
+		ok     bool
+	}{
+		{"route TTL", route, http.StatusOK, http.Header{}, 30 * time.Second, true},
+		{"default TTL", perUser, http.StatusOK, http.Header{}, defaultCacheTTL, true},
+		{"max-age", route, http.StatusOK, http.Header{"Cache-Control": {"public, max-age=120"}}, 2 * time.Minute, true},
+		{"s-maxage wins", route, http.StatusOK, http.Header{"Cache-Control": {"max-age=120, s-maxage=10"}}, 10 * time.Second, true},
+		{"quoted max-age", route, http.StatusOK, http.Header{"Cache-Control": {`max-age="60"`}}, time.Minute, true},
+		{"zero max-age", route, http.StatusOK, http.Header{"Cache-Control": {"max-age=0"}}, 0, false},
+		{"bad max-age", route, http.StatusOK, http.Header{"Cache-Control": {"max-age=soon"}}, 0, false},
+		{"no-store", route, http.StatusOK, http.Header{"Cache-Control": {"no-store"}}, 0, false},
+		{"no-cache", route, http.StatusOK, http.Header{"Cache-Control": {"No-Cache"}}, 0, false},
+		{"private", route, http.StatusOK, http.Header{"Cache-Control": {"private, max-age=60"}}, 0, false},
+		{"private varying by user", perUser, http.StatusOK, http.Header{"Cache-Control": {"private, max-age=60"}}, time.Minute, true},
+		{"vary accept-encoding", route, http.StatusOK, http.Header{"Vary": {"Accept-Encoding"}}, 30 * time.Second, true},
+		{"vary other header", route, http.StatusOK, http.Header{"Vary": {"Accept-Encoding, Accept-Language"}}, 0, false},
+		{"set-cookie", route, http.StatusOK, http.Header{"Set-Cookie": {"session=1"}}, 0, false},
+		{"not 200", route, http.StatusNotFound, http.Header{}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ttl, ok := tt.r.cacheTTL(&http.Response{StatusCode: tt.status, Header: tt.header})
+			if ttl != tt.ttl || ok != tt.ok {
+				t.Errorf("cacheTTL = %s, %v; want %s, %v", ttl, ok, tt.ttl, tt.ok)
+			}
+		})
+	}
+}
+
+func TestCacheHitsAreNotCharged(t *testing.T) {
+	var calls atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.Write([]byte("report"))
+	}))
+	defer upstream.Close()
+
+	r, err := NewRoute(&database.CustomEndpoint{
+		Path:      "/admit/*path",
+		Endpoints: []string{upstream.URL},
+		Cache:     database.ResponseCache{Enabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ID = 1_000_101
+
+	status, charged := serveChain(t, r, httptest.NewRequest(http.MethodGet, "/admit/reports", nil))
+	if status != http.StatusOK || !charged {
+		t.Fatalf("miss: got %d, charged %v; want 200, charged", status, charged)
+	}
+
+	status, charged = serveChain(t, r, httptest.NewRequest(http.MethodGet, "/admit/reports", nil))
+	if status != http.StatusOK || charged {
+		t.Fatalf("hit: got %d, charged %v; want 200, not charged", status, charged)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("upstream called %d times, want 1", n)
+	}
+}
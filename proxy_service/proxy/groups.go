@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"sync/atomic"
+
+	"auth_service/caller"
+	"auth_service/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultGroup names the implicit group built from a route's flat Endpoints.
+const defaultGroup = "default"
+
+// targetGroup is a weighted set of a route's targets with its own balancer.
+type targetGroup struct {
+	name     string
+	weight   int64 // Updated atomically so weights can shift while live
+	targets  []*Target
+	balancer Balancer
+}
+
+// GroupStatus is the admin view of a target group.
+type GroupStatus struct {
+	Name    string   `json:"name"`
+	Weight  int64    `json:"weight"`
+	Targets []string `json:"targets"`
+}
+
+// buildGroups builds the target groups of ep. Endpoints without
+// TargetGroups form a single group taking all traffic.
+func buildGroups(ep *database.CustomEndpoint) ([]*targetGroup, error) {
+	specs := ep.TargetGroups
+	if len(specs) == 0 {
+		specs = []database.TargetGroup{{Name: defaultGroup, Endpoints: ep.Endpoints, Weights: ep.Weights, Weight: 1}}
+	} else if len(ep.Endpoints) > 0 {
+		return nil, errors.New("use either endpoints or targetGroups, not both")
+	}
+
+	groups := make([]*targetGroup, 0, len(specs))
+	seen := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if spec.Name == "" || seen[spec.Name] {
+			return nil, fmt.Errorf("target group names must be set and unique, got %q", spec.Name)
+		}
+		seen[spec.Name] = true
+		if spec.Weight < 0 {
+			return nil, fmt.Errorf("target group %s has a negative weight", spec.Name)
+		}
+		if len(spec.Endpoints) == 0 {
+			return nil, fmt.Errorf("target group %s has no target endpoints", spec.Name)
+		}
+		if len(spec.Weights) > len(spec.Endpoints) {
+			return nil, fmt.Errorf("target group %s has more weights than endpoints", spec.Name)
+		}
+
+		g := &targetGroup{name: spec.Name, weight: int64(spec.Weight)}
+		for i, endpoint := range spec.Endpoints {
+			weight := 1
+			if i < len(spec.Weights) {
+				weight = int(spec.Weights[i])
+			}
+
+			t, err := NewTarget(endpoint, weight)
+			if err != nil {
+				return nil, err
+			}
+			t.route = ep.Path
+			t.breaker = newBreaker(ep.CircuitBreaker, ep.Path, t.URL.String())
+			g.targets = append(g.targets, t)
+		}
+
+		balancer, err := NewBalancer(ep.LoadBalancing, g.targets)
+		if err != nil {
+			return nil, err
+		}
+		g.balancer = balancer
+		groups = append(groups, g)
+	}
+
+	var total int64
+	for _, g := range groups {
+		total += g.weight
+	}
+	if total == 0 {
+		return nil, errors.New("at least one target group needs a positive weight")
+	}
+
+	for _, o := range ep.TrafficOverrides {
+		if !seen[o.Group] {
+			return nil, fmt.Errorf("traffic override targets unknown group %q", o.Group)
+		}
+	}
+	return groups, nil
+}
+
+// Groups returns the route's target groups and their current weights.
+func (r *Route) Groups() []GroupStatus {
+	statuses := make([]GroupStatus, 0, len(r.groups))
+	for _, g := range r.groups {
+		status := GroupStatus{Name: g.name, Weight: atomic.LoadInt64(&g.weight)}
+		for _, t := range g.targets {
+			status.Targets = append(status.Targets, t.URL.String())
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// SetGroupWeights changes the traffic share of the named groups without
+// interrupting requests in flight.
+func (r *Route) SetGroupWeights(weights map[string]int) error {
+	byName := make(map[string]*targetGroup, len(r.groups))
+	for _, g := range r.groups {
+		byName[g.name] = g
+	}
+	for name, weight := range weights {
+		if byName[name] == nil {
+			return fmt.Errorf("unknown target group %q", name)
+		}
+		if weight < 0 {
+			return fmt.Errorf("target group %s has a negative weight", name)
+		}
+	}
+
+	for name, weight := range weights {
+		atomic.StoreInt64(&byName[name].weight, int64(weight))
+	}
+	return nil
+}
+
+// selectGroup picks the group serving the request along with its available
+// targets. Overrides win; otherwise key is hashed onto the weights so a
+// caller keeps hitting the same group while the weights stay put, and only
+// moves when a shift reassigns its slot. Groups without available targets
+// are skipped. It returns nil when no target is available at all.
+func (r *Route) selectGroup(c *gin.Context, key string) (*targetGroup, []*Target) {
+	if g := r.overrideGroup(c); g != nil {
+		if candidates := r.candidates(g.targets); len(candidates) > 0 {
+			return g, candidates
+		}
+	}
+
+	type option struct {
+		group      *targetGroup
+		candidates []*Target
+		weight     int64
+	}
+	var options []option
+	var total int64
+	for _, g := range r.groups {
+		weight := atomic.LoadInt64(&g.weight)
+		if weight <= 0 {
+			continue
+		}
+		if candidates := r.candidates(g.targets); len(candidates) > 0 {
+			options = append(options, option{g, candidates, weight})
+			total += weight
+		}
+	}
+	if total == 0 {
+		return nil, nil
+	}
+
+	var slot int64
+	if key != "" {
+		slot = int64(crc32.ChecksumIEEE([]byte(key))) % total
+	} else {
+		slot = rand.Int63n(total)
+	}
+	for _, o := range options {
+		if slot < o.weight {
+			return o.group, o.candidates
+		}
+		slot -= o.weight
+	}
+	return nil, nil
+}
+
+// overrideGroup returns the group the first matching override pins the
+// request to, or nil.
+func (r *Route) overrideGroup(c *gin.Context) *targetGroup {
+	for _, o := range r.overrides {
+		if !overrideMatches(c, o) {
+			continue
+		}
+		for _, g := range r.groups {
+			if g.name == o.Group {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+func overrideMatches(c *gin.Context, o database.TrafficOverride) bool {
+	if o.Header != "" {
+		if v := c.GetHeader(o.Header); v != "" && (o.Value == "" || v == o.Value) {
+			return true
+		}
+	}
+	if o.Cookie != "" {
+		if v, err := c.Cookie(o.Cookie); err == nil && v != "" && (o.Value == "" || v == o.Value) {
+			return true
+		}
+	}
+	if len(o.Users) > 0 {
+		who, _ := caller.FromContext(c)
+		for _, u := range o.Users {
+			if u == who.User {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"auth_service/database"
+)
+
+// Health-check defaults used when the route leaves a value unset.
+const (
+	defaultProbeInterval      = 10 * time.Second
+	defaultProbeTimeout       = 2 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+	defaultEjectionCooldown   = 30 * time.Second
+)
+
+// targetHealth tracks the result of active probes and passive observations
+// for a single target.
+type targetHealth struct {
+	mu sync.Mutex
+
+	// Active probing.
+	unhealthy     bool
+	probeSuccess  int
+	probeFailures int
+	lastProbe     time.Time
+	lastError     string
+
+	// Passive ejection.
+	failures     int
+	ejectedUntil time.Time
+}
+
+// TargetStatus is a snapshot of a target's health, as reported by the admin API.
+type TargetStatus struct {
+	URL                 string     `json:"url"`
+	Available           bool       `json:"available"`
+	Healthy             bool       `json:"healthy"`
+	EjectedUntil        *time.Time `json:"ejectedUntil,omitempty"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	ActiveRequests      int64      `json:"activeRequests"`
+	LastProbe           *time.Time `json:"lastProbe,omitempty"`
+	LastError           string     `json:"lastError,omitempty"`
+	Circuit             string     `json:"circuit"`
+	CircuitOpens        int        `json:"circuitOpens"`
+}
+
+// Available reports whether the target may receive traffic: it is not marked
+// unhealthy by active probes, not ejected by passive checks and its circuit
+// breaker lets requests through.
+func (t *Target) Available(now time.Time) bool {
+	t.health.mu.Lock()
+	healthy := !t.health.unhealthy && !now.Before(t.health.ejectedUntil)
+	t.health.mu.Unlock()
+
+	return healthy && t.breaker.ready(now)
+}
+
+// Status returns a snapshot of the target's health.
+func (t *Target) Status() TargetStatus {
+	now := time.Now()
+	available := t.Available(now)
+	circuit, opens := t.breaker.snapshot()
+
+	t.health.mu.Lock()
+	defer t.health.mu.Unlock()
+
+	s := TargetStatus{
+		URL:                 t.URL.String(),
+		Available:           available,
+		Healthy:             !t.health.unhealthy,
+		ConsecutiveFailures: t.health.failures,
+		ActiveRequests:      t.Active(),
+		LastError:           t.health.lastError,
+		Circuit:             circuit.String(),
+		CircuitOpens:        opens,
+	}
+	if now.Before(t.health.ejectedUntil) {
+		until := t.health.ejectedUntil
+		s.EjectedUntil = &until
+	}
+	if !t.health.lastProbe.IsZero() {
+		last := t.health.lastProbe
+		s.LastProbe = &last
+	}
+	return s
+}
+
+// report feeds the outcome of a proxied request to passive health checking
+// and the circuit breaker. ctx is the request's, for logging.
+func (t *Target) report(ctx context.Context, cfg *database.HealthCheck, o outcome) {
+	if o != outcomeIgnored {
+		t.observe(ctx, cfg, o == outcomeFailure)
+	}
+	t.breaker.record(ctx, o)
+}
+
+// observe records the outcome of a proxied request for passive ejection.
+func (t *Target) observe(ctx context.Context, cfg *database.HealthCheck, failed bool) {
+	if cfg.MaxFailures <= 0 {
+		return
+	}
+
+	t.health.mu.Lock()
+	defer t.health.mu.Unlock()
+
+	if !failed {
+		t.health.failures = 0
+		return
+	}
+
+	t.health.failures++
+	if t.health.failures >= cfg.MaxFailures {
+		cooldown := cfg.Cooldown.Or(defaultEjectionCooldown)
+		t.health.ejectedUntil = time.Now().Add(cooldown)
+		t.health.failures = 0
+		slog.WarnContext(ctx, "Ejected upstream after consecutive failures", "route", t.route, "target", t.URL.String(), "failures", cfg.MaxFailures, "cooldown", cooldown.String())
+	}
+}
+
+// recordProbe applies the result of an active probe to the target.
+func (t *Target) recordProbe(cfg *database.HealthCheck, err error) {
+	healthyThreshold := cfg.HealthyThreshold
+	if healthyThreshold <= 0 {
+		healthyThreshold = defaultHealthyThreshold
+	}
+	unhealthyThreshold := cfg.UnhealthyThreshold
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	t.health.mu.Lock()
+	defer t.health.mu.Unlock()
+
+	t.health.lastProbe = time.Now()
+	if err == nil {
+		t.health.lastError = ""
+		t.health.probeFailures = 0
+		t.health.probeSuccess++
+		if t.health.unhealthy && t.health.probeSuccess >= healthyThreshold {
+			t.health.unhealthy = false
+			slog.Info("Upstream is healthy again", "route", t.route, "target", t.URL.String())
+		}
+		return
+	}
+
+	t.health.lastError = err.Error()
+	t.health.probeSuccess = 0
+	t.health.probeFailures++
+	if !t.health.unhealthy && t.health.probeFailures >= unhealthyThreshold {
+		t.health.unhealthy = true
+		slog.Warn("Upstream marked unhealthy", "route", t.route, "target", t.URL.String(), "error", err)
+	}
+}
+
+// startProbes launches one active health-check loop per target, running
+// until stop is called. It does nothing when the route has no probe path
+// configured.
+func (r *Route) startProbes() {
+	if r.healthCheck.Path == "" {
+		return
+	}
+
+	timeout := r.healthCheck.Timeout.Or(defaultProbeTimeout)
+	interval := r.healthCheck.Interval.Or(defaultProbeInterval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stopProbes = cancel
+
+	for _, t := range r.targets {
+		go func(t *Target) {
+			// Probes go through the target's transport to use its TLS settings.
+			client := &http.Client{Timeout: timeout, Transport: t.transport}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for {
+				err := probe(ctx, client, t, r.healthCheck.Path)
+				if ctx.Err() != nil {
+					return
+				}
+				t.recordProbe(&r.healthCheck, err)
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(t)
+	}
+}
+
+// stop ends the route's active health checks, including probes in flight.
+func (r *Route) stop() {
+	if r.stopProbes != nil {
+		r.stopProbes()
+	}
+}
+
+// probe issues a GET to path on the target; any 2xx or 3xx answer is healthy.
+func probe(ctx context.Context, client *http.Client, t *Target, path string) error {
+	u := *t.URL
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(path, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("health check returned %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"auth_service/caller"
+	"auth_service/config"
+	"auth_service/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Identity propagation modes.
+const (
+	IdentityNone    = ""
+	IdentityHeaders = "headers"
+	IdentityToken   = "token"
+)
+
+// Headers carrying the caller's identity to upstream services. Clients can
+// never set them: the proxy removes them from every incoming request.
+const (
+	HeaderAuthUser      = "X-Auth-User"
+	HeaderAuthRole      = "X-Auth-Role"
+	HeaderAuthTenant    = "X-Auth-Tenant"
+	HeaderAuthTimestamp = "X-Auth-Timestamp"
+	HeaderAuthSignature = "X-Auth-Signature"
+	HeaderInternalToken = "X-Internal-Token"
+	HeaderRequestID     = logging.HeaderRequestID
+)
+
+// identityKey is the request context key holding the caller's *identity.
+type identityKey struct{}
+
+// identity is the authenticated caller of a proxied request.
+type identity struct {
+	User      string
+	Role      string
+	Tenant    string
+	RequestID string
+}
+
+// validateIdentityMode checks that mode is known and can be served.
+func validateIdentityMode(mode string) error {
+	switch mode {
+	case IdentityNone:
+		return nil
+	case IdentityHeaders, IdentityToken:
+		if config.IdentitySecret == "" {
+			return errors.New("IDENTITY_SECRET must be set to propagate identities")
+		}
+		return nil
+	default:
+		return errors.New("unknown identity mode " + strconv.Quote(mode))
+	}
+}
+
+// identityFromContext reads the caller from the JWT claims set by AuthMiddleware.
+func identityFromContext(c *gin.Context) *identity {
+	// The access log middleware assigned the request its ID.
+	id := &identity{RequestID: logging.RequestID(c.Request.Context())}
+	if id.RequestID == "" {
+		id.RequestID = logging.NewRequestID()
+	}
+
+	who, _ := caller.FromContext(c)
+	id.User, id.Role, id.Tenant = who.User, who.Role, who.Tenant
+	return id
+}
+
+// applyIdentity strips client-supplied identity headers from the outgoing
+// request and, depending on the route's mode, adds signed ones.
+func (r *Route) applyIdentity(req *http.Request) {
+	for _, h := range []string{HeaderAuthUser, HeaderAuthRole, HeaderAuthTenant, HeaderAuthTimestamp, HeaderAuthSignature, HeaderInternalToken} {
+		req.Header.Del(h)
+	}
+	if r.identity.StripAuthorization {
+		req.Header.Del("Authorization")
+	}
+
+	id, ok := req.Context().Value(identityKey{}).(*identity)
+	if !ok {
+		return
+	}
+	req.Header.Set(HeaderRequestID, id.RequestID)
+
+	switch r.identity.Mode {
+	case IdentityHeaders:
+		SetIdentityHeaders(req.Header, id.User, id.Role, id.Tenant, id.RequestID)
+
+	case IdentityToken:
+		now := time.Now()
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"iss":    "auth_service",
+			"user":   id.User,
+			"role":   id.Role,
+			"tenant": id.Tenant,
+			"rid":    id.RequestID,
+			"iat":    now.Unix(),
+			"exp":    now.Add(config.InternalTokenTTL).Unix(),
+		})
+		if signed, err := token.SignedString([]byte(config.IdentitySecret)); err == nil {
+			req.Header.Set(HeaderInternalToken, signed)
+		}
+	}
+}
+
+// SetIdentityHeaders sets the X-Auth-* headers and request ID of a caller on
+// h, signed with IDENTITY_SECRET.
+func SetIdentityHeaders(h http.Header, user, role, tenant, requestID string) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	h.Set(HeaderAuthUser, user)
+	h.Set(HeaderAuthRole, role)
+	h.Set(HeaderAuthTenant, tenant)
+	h.Set(HeaderRequestID, requestID)
+	h.Set(HeaderAuthTimestamp, timestamp)
+	h.Set(HeaderAuthSignature, SignIdentity(config.IdentitySecret, user, role, tenant, requestID, timestamp))
+}
+
+// SignIdentity returns the hex HMAC-SHA256 over the identity header values.
+// Upstreams recompute it with the shared IDENTITY_SECRET to trust the headers.
+func SignIdentity(secret, user, role, tenant, requestID, timestamp string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{user, role, tenant, requestID, timestamp}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,12 @@
+package proxy
+
+import "testing"
+
+// TestSignIdentityGolden pins the signature format to the one the services
+// verify with the signedidentity module, whose test holds the same value.
+func TestSignIdentityGolden(t *testing.T) {
+	const want = "5e82f4ab78a96afef7862dabe942d060cbce633501318b7f63c89b2fc3836f56"
+	if got := SignIdentity("secret", "alice", "admin", "acme", "req-1", "1700000000"); got != want {
+		t.Fatalf("SignIdentity = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"auth_service/database"
+)
+
+// defaultConnectTimeout matches the dial timeout of http.DefaultTransport.
+const defaultConnectTimeout = 30 * time.Second
+
+// errResponseTooLarge is returned when an upstream answer exceeds the
+// route's MaxResponseBytes.
+var errResponseTooLarge = errors.New("upstream response too large")
+
+// newTransport builds an upstream transport of a route from its timeouts,
+// upstream protocol and TLS configuration, if any.
+func newTransport(timeouts database.Timeouts, protocol string, tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{
+		Timeout:   timeouts.Connect.Or(defaultConnectTimeout),
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.ResponseHeaderTimeout = time.Duration(timeouts.ResponseHeader)
+	transport.Protocols = upstreamProtocols(protocol)
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	return transport
+}
+
+// limitRequest rejects or caps the request body according to MaxRequestBytes.
+// It reports false when the request was answered with 413.
+func (r *Route) limitRequest(w http.ResponseWriter, req *http.Request) bool {
+	if r.maxRequestBytes <= 0 || req.Body == nil {
+		return true
+	}
+	if req.ContentLength > r.maxRequestBytes {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return false
+	}
+	req.Body = http.MaxBytesReader(w, req.Body, r.maxRequestBytes)
+	return true
+}
+
+// withDeadline applies the route's total timeout to the request context.
+func (r *Route) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeouts.Total <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(r.timeouts.Total))
+}
+
+// limitResponse enforces MaxResponseBytes. Answers of unknown length are
+// buffered up to the limit so an oversized body can still be refused before
+// anything reaches the client.
+func (r *Route) limitResponse(resp *http.Response) error {
+	if r.maxResponseBytes <= 0 {
+		return nil
+	}
+	if resp.ContentLength > r.maxResponseBytes {
+		return errResponseTooLarge
+	}
+	if resp.ContentLength >= 0 {
+		return nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, r.maxResponseBytes+1))
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(buf)) > r.maxResponseBytes {
+		return errResponseTooLarge
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(buf))
+	return nil
+}
+
+// errorStatus maps a proxying error to the status and message sent to the client.
+func errorStatus(err error) (int, string) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge, "Request body too large"
+	}
+
+	if errors.Is(err, errResponseTooLarge) || errors.Is(err, errBodyTooLarge) {
+		return http.StatusBadGateway, "Upstream response too large"
+	}
+
+	if errors.Is(err, errResponseTransform) {
+		return http.StatusBadGateway, "Upstream response transformation failed"
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return http.StatusGatewayTimeout, "Upstream request timed out"
+	}
+
+	return http.StatusBadGateway, "Upstream request failed"
+}
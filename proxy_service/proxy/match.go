@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"auth_service/database"
+)
+
+// Matcher decides whether a request is served by a custom endpoint sharing
+// its path with others.
+type Matcher struct {
+	hosts   []string
+	sni     []string
+	methods []string
+	headers map[string]string
+	query   map[string]string
+}
+
+// NewMatcher builds the matcher of ep from its match rules. A Method other
+// than ANY restricts the methods when the rules list none.
+func NewMatcher(ep *database.CustomEndpoint) (*Matcher, error) {
+	m := &Matcher{
+		hosts:   lowerAll(ep.Match.Hosts),
+		sni:     lowerAll(ep.Match.SNI),
+		headers: ep.Match.Headers,
+		query:   ep.Match.Query,
+	}
+
+	for _, host := range append(append([]string(nil), m.hosts...), m.sni...) {
+		if host == "" || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return nil, fmt.Errorf("invalid host pattern %q", host)
+		}
+	}
+
+	methods := ep.Match.Methods
+	if len(methods) == 0 && ep.Method != "" && ep.Method != "ANY" {
+		methods = []string{ep.Method}
+	}
+	for _, method := range methods {
+		method = strings.ToUpper(method)
+		if method == "ANY" {
+			m.methods = nil
+			break
+		}
+		m.methods = append(m.methods, method)
+	}
+
+	for name := range m.headers {
+		if strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return m, nil
+}
+
+// Specificity is the number of conditions of the matcher. Among endpoints
+// of equal priority the most specific is tried first.
+func (m *Matcher) Specificity() int {
+	n := len(m.headers) + len(m.query)
+	for _, set := range [][]string{m.hosts, m.sni, m.methods} {
+		if len(set) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// Methods returns the methods the matcher accepts, or nil for any.
+func (m *Matcher) Methods() []string {
+	return m.methods
+}
+
+// Matches reports whether req satisfies all of the matcher's conditions.
+func (m *Matcher) Matches(req *http.Request) bool {
+	if len(m.methods) > 0 && !contains(m.methods, req.Method) {
+		return false
+	}
+
+	if len(m.hosts) > 0 {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !matchHost(m.hosts, strings.ToLower(host)) {
+			return false
+		}
+	}
+
+	if len(m.sni) > 0 {
+		if req.TLS == nil || !matchHost(m.sni, strings.ToLower(req.TLS.ServerName)) {
+			return false
+		}
+	}
+
+	for name, want := range m.headers {
+		values := req.Header.Values(name)
+		if len(values) == 0 || (want != "*" && !contains(values, want)) {
+			return false
+		}
+	}
+
+	if len(m.query) > 0 {
+		query := req.URL.Query()
+		for name, want := range m.query {
+			values, ok := query[name]
+			if !ok || (want != "*" && !contains(values, want)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchHost reports whether host matches one of patterns. "*.example.com"
+// matches any subdomain of example.com but not example.com itself.
+func matchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+			if strings.HasSuffix(host, suffix) && len(host) > len(suffix) {
+				return true
+			}
+		} else if host == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func lowerAll(values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		out = append(out, strings.ToLower(strings.TrimSpace(v)))
+	}
+	return out
+}
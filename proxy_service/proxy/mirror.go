@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"auth_service/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Mirroring defaults used when the route leaves a value unset.
+const (
+	defaultMirrorTimeout       = 5 * time.Second
+	defaultMirrorMaxBodyBytes  = 1 << 20
+	defaultMirrorMaxConcurrent = 100
+)
+
+// HeaderShadowRequest marks requests sent to a mirror target.
+const HeaderShadowRequest = "X-Shadow-Request"
+
+// mirror shadows a sample of a route's requests to a secondary upstream and
+// compares the outcomes with the primary responses.
+type mirror struct {
+	target        *url.URL
+	percent       float64
+	maxBytes      int64
+	nonIdempotent bool
+	client        *http.Client
+	slots         chan struct{}
+
+	mirrored, dropped           int64
+	primaryErrors, shadowErrors int64
+	mismatches                  int64
+	primaryNanos, shadowNanos   int64
+	primaryCount, shadowCount   int64
+}
+
+// MirrorStats compares the shadow upstream with the primary one over the
+// mirrored requests. Errors are transport failures and 5xx answers.
+type MirrorStats struct {
+	Target           string  `json:"target"`
+	Percent          float64 `json:"percent"`
+	Mirrored         int64   `json:"mirrored"`
+	Dropped          int64   `json:"dropped"` // Not mirrored because too many shadow requests were in flight
+	PrimaryErrors    int64   `json:"primaryErrors"`
+	ShadowErrors     int64   `json:"shadowErrors"`
+	StatusMismatches int64   `json:"statusMismatches"`
+	PrimaryLatencyMs float64 `json:"primaryLatencyMs"` // Mean over mirrored requests
+	ShadowLatencyMs  float64 `json:"shadowLatencyMs"`
+}
+
+func newMirror(cfg database.Mirror) (*mirror, error) {
+	if cfg.Target == "" {
+		return nil, nil
+	}
+
+	target, err := url.Parse(cfg.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("invalid mirror target %q", cfg.Target)
+	}
+	percent := 100.0
+	if cfg.Percent != nil {
+		percent = *cfg.Percent
+	}
+	if percent < 0 || percent > 100 {
+		return nil, fmt.Errorf("mirror percent must be between 0 and 100, got %v", percent)
+	}
+
+	m := &mirror{
+		target:        target,
+		percent:       percent,
+		maxBytes:      cfg.MaxBodyBytes,
+		nonIdempotent: cfg.NonIdempotent,
+		client:        &http.Client{Timeout: cfg.Timeout.Or(defaultMirrorTimeout)},
+	}
+	if m.maxBytes <= 0 {
+		m.maxBytes = defaultMirrorMaxBodyBytes
+	}
+	maxConcurrent := cfg.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = defaultMirrorMaxConcurrent
+	}
+	m.slots = make(chan struct{}, maxConcurrent)
+
+	// Never follow redirects: the shadow's answer is only measured.
+	m.client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return m, nil
+}
+
+// Mirror returns the route's mirroring statistics, or nil when the route is
+// not mirrored.
+func (r *Route) Mirror() *MirrorStats {
+	m := r.mirror
+	if m == nil {
+		return nil
+	}
+	return &MirrorStats{
+		Target:           m.target.String(),
+		Percent:          m.percent,
+		Mirrored:         atomic.LoadInt64(&m.mirrored),
+		Dropped:          atomic.LoadInt64(&m.dropped),
+		PrimaryErrors:    atomic.LoadInt64(&m.primaryErrors),
+		ShadowErrors:     atomic.LoadInt64(&m.shadowErrors),
+		StatusMismatches: atomic.LoadInt64(&m.mismatches),
+		PrimaryLatencyMs: meanMillis(atomic.LoadInt64(&m.primaryNanos), atomic.LoadInt64(&m.primaryCount)),
+		ShadowLatencyMs:  meanMillis(atomic.LoadInt64(&m.shadowNanos), atomic.LoadInt64(&m.shadowCount)),
+	}
+}
+
+func meanMillis(nanos, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(nanos) / float64(count) / float64(time.Millisecond)
+}
+
+// shadow is one mirrored request. Its outcome is compared with the primary
+// one once both are known.
+type shadow struct {
+	m        *mirror
+	mu       sync.Mutex
+	statuses []int // 0 for transport errors
+}
+
+func (s *shadow) complete(status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.statuses = append(s.statuses, status)
+	if len(s.statuses) == 2 && s.statuses[0] != s.statuses[1] {
+		atomic.AddInt64(&s.m.mismatches, 1)
+	}
+}
+
+// startMirror sends a copy of the request to the route's mirror target, if
+// the request is sampled. It returns a function recording the primary
+// outcome, or nil when the request is not mirrored. The request body is
+// buffered so both upstreams receive it.
+func (r *Route) startMirror(c *gin.Context, id *identity) func(status int, latency time.Duration) {
+	m := r.mirror
+	if m == nil || isStreamingRequest(c.Request) || !m.mirrors(c.Request.Method) || rand.Float64()*100 >= m.percent {
+		return nil
+	}
+
+	req := c.Request
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		buf, err := io.ReadAll(io.LimitReader(req.Body, m.maxBytes+1))
+		if err != nil || int64(len(buf)) > m.maxBytes {
+			// Too large or unreadable: let the primary request stream it.
+			req.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+			return nil
+		}
+		req.Body.Close()
+		body = buf
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		atomic.AddInt64(&m.dropped, 1)
+		return nil
+	}
+	atomic.AddInt64(&m.mirrored, 1)
+
+	out := r.shadowRequest(req, body, id)
+	s := &shadow{m: m}
+	ctx := req.Context()
+	go func() {
+		defer func() { <-m.slots }()
+
+		start := time.Now()
+		resp, err := m.client.Do(out)
+		status := 0
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			status = resp.StatusCode
+		}
+		atomic.AddInt64(&m.shadowNanos, int64(time.Since(start)))
+		atomic.AddInt64(&m.shadowCount, 1)
+		if status == 0 || status >= http.StatusInternalServerError {
+			atomic.AddInt64(&m.shadowErrors, 1)
+			if err != nil {
+				slog.WarnContext(ctx, "Shadow request failed", "route", r.Path, "target", m.target, "error", err)
+			}
+		}
+		s.complete(status)
+	}()
+
+	return func(status int, latency time.Duration) {
+		atomic.AddInt64(&m.primaryNanos, int64(latency))
+		atomic.AddInt64(&m.primaryCount, 1)
+		if status >= http.StatusInternalServerError {
+			atomic.AddInt64(&m.primaryErrors, 1)
+		}
+		s.complete(status)
+	}
+}
+
+// mirrors reports whether requests with method may be mirrored.
+func (m *mirror) mirrors(method string) bool {
+	return m.nonIdempotent || isIdempotent(method)
+}
+
+// shadowRequest builds the copy of req sent to the mirror target, rewritten
+// the same way as the primary request.
+func (r *Route) shadowRequest(req *http.Request, body []byte, id *identity) *http.Request {
+	ctx := context.WithValue(context.Background(), identityKey{}, id)
+	out := req.Clone(ctx)
+	out.RequestURI = ""
+	out.Body = http.NoBody
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.ContentLength = int64(len(body))
+	}
+
+	for _, h := range []string{"Connection", "Keep-Alive", "Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade"} {
+		out.Header.Del(h)
+	}
+	r.rewriter.request(out)
+	r.applyIdentity(out)
+	out.Header.Set(HeaderShadowRequest, "true")
+
+	out.URL.Scheme = r.mirror.target.Scheme
+	out.URL.Host = r.mirror.target.Host
+	out.URL.Path = r.mirror.target.Path + r.rewriter.path(strings.TrimPrefix(req.URL.Path, r.basePath))
+	out.URL.RawPath = ""
+	out.Host = r.mirror.target.Host
+	return out
+}
@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"net/http"
+	"testing"
+
+	"auth_service/database"
+)
+
+func TestMirrorPercent(t *testing.T) {
+	percent := func(p float64) *float64 { return &p }
+
+	tests := []struct {
+		name    string
+		percent *float64
+		want    float64
+		wantErr bool
+	}{
+		{"unset mirrors everything", nil, 100, false},
+		{"zero pauses mirroring", percent(0), 0, false},
+		{"partial", percent(12.5), 12.5, false},
+		{"negative", percent(-1), 0, true},
+		{"over 100", percent(101), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMirror(database.Mirror{Target: "http://shadow.internal", Percent: tt.percent})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.percent != tt.want {
+				t.Fatalf("percent = %v, want %v", m.percent, tt.want)
+			}
+		})
+	}
+}
+
+func TestMirrorMethods(t *testing.T) {
+	tests := []struct {
+		method        string
+		nonIdempotent bool
+		want          bool
+	}{
+		{http.MethodGet, false, true},
+		{http.MethodPut, false, true},
+		{http.MethodDelete, false, true},
+		{http.MethodPost, false, false},
+		{http.MethodPatch, false, false},
+		{http.MethodPost, true, true},
+		{http.MethodPatch, true, true},
+	}
+	for _, tt := range tests {
+		m, err := newMirror(database.Mirror{Target: "http://shadow.internal", NonIdempotent: tt.nonIdempotent})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := m.mirrors(tt.method); got != tt.want {
+			t.Errorf("mirrors(%s) with nonIdempotent=%v = %v, want %v", tt.method, tt.nonIdempotent, got, tt.want)
+		}
+	}
+}
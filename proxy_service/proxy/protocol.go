@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"auth_service/config"
+)
+
+// Upstream protocols.
+const (
+	ProtocolHTTP = "http" // HTTP/1.1, or HTTP/2 when negotiated with https targets (default)
+	ProtocolH2C  = "h2c"  // HTTP/2 over cleartext with prior knowledge, http:// targets only
+	ProtocolH2   = "h2"   // HTTP/2 over TLS, https:// targets only
+	ProtocolGRPC = "grpc" // HTTP/2 (h2c or TLS by target scheme), routed at the server root
+)
+
+// validateProtocol checks that protocol is known and fits the target schemes.
+func validateProtocol(protocol string, targets []*Target) error {
+	var scheme string
+	switch protocol {
+	case "", ProtocolHTTP, ProtocolGRPC:
+		return nil
+	case ProtocolH2C:
+		scheme = "http"
+	case ProtocolH2:
+		scheme = "https"
+	default:
+		return fmt.Errorf("unknown protocol %q", protocol)
+	}
+
+	for _, t := range targets {
+		if t.URL.Scheme != scheme {
+			return fmt.Errorf("protocol %s needs %s:// targets, got %s", protocol, scheme, t.URL)
+		}
+	}
+	return nil
+}
+
+// upstreamProtocols returns the protocols the route's transport may speak,
+// or nil for the transport's defaults.
+func upstreamProtocols(protocol string) *http.Protocols {
+	switch protocol {
+	case ProtocolH2C, ProtocolH2, ProtocolGRPC:
+		p := new(http.Protocols)
+		p.SetHTTP2(true)
+		p.SetUnencryptedHTTP2(true)
+		return p
+	}
+	return nil
+}
+
+// BasePath returns the path prefix a route with protocol is served under.
+// gRPC clients call /package.Service/Method, so gRPC routes live at the
+// server root instead of under config.BaseApi.
+func BasePath(protocol string) string {
+	if protocol == ProtocolGRPC {
+		return ""
+	}
+	return config.BaseApi
+}
+
+// IsGRPCRequest reports whether req is a gRPC call.
+func IsGRPCRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// GRPCMethod returns the full method name of a gRPC call,
+// "/package.Service/Method", taken from the last two path segments.
+func GRPCMethod(req *http.Request) string {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return path
+	}
+	if j := strings.LastIndex(path[:i], "/"); j >= 0 {
+		return path[j:]
+	}
+	return path
+}
@@ -1,58 +1,368 @@
 package proxy
 
 import (
-	"auth_service/config"
+	"auth_service/caller"
+	"auth_service/database"
 
-	"math/rand"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// NewMultiTargetReverseProxy creates a reverse proxy that load-balances requests
-// among the provided target URLs.
-func NewMultiTargetReverseProxy(targets []*url.URL) *httputil.ReverseProxy {
-	// Create a new random generator using a custom seed.
-	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+// attemptKey is the request context key holding the *attempt of a request.
+type attemptKey struct{}
 
-	// Custom director function to choose a target per request.
-	director := func(req *http.Request) {
-		// Pick a target randomly.
-		target := targets[rng.Intn(len(targets))]
+// Route holds the proxying state of a single custom endpoint. It is built once
+// when the endpoint is registered so that balancer state (round-robin
+// counters, connection counts, hash ring) survives across requests.
+type Route struct {
+	ID       uint
+	Path     string
+	Priority int
 
-		// Update the scheme and host of the request to match the selected target.
-		req.URL.Scheme = target.Scheme
-		req.URL.Host = target.Host
+	matcher     *Matcher
+	basePath    string    // config.BaseApi, or "" for gRPC routes
+	targets     []*Target // Targets of all groups
+	groups      []*targetGroup
+	overrides   []database.TrafficOverride
+	hashHeader  string
+	healthCheck database.HealthCheck
+	retry       database.RetryPolicy
+	timeouts    database.Timeouts
+	identity    database.Identity
+	rewriter    *rewriter
+	transformer *transformer
+	cache       database.ResponseCache
+	stream      database.Streaming
+	streams     StreamStats // Updated atomically
+	mirror      *mirror
+	proxy       *httputil.ReverseProxy
+	stopProbes  context.CancelFunc // Set by startProbes
 
-		newPath := ""
-		if strings.HasPrefix(req.URL.Path, config.BaseApi) {
-			newPath = strings.TrimPrefix(req.URL.Path, config.BaseApi)
+	maxRequestBytes  int64
+	maxResponseBytes int64
+}
+
+// NewRoute builds the proxy for ep using its configured load-balancing strategy.
+func NewRoute(ep *database.CustomEndpoint) (*Route, error) {
+	if len(ep.Endpoints) == 0 && len(ep.TargetGroups) == 0 {
+		return nil, errors.New("custom endpoint has no target endpoints")
+	}
+
+	groups, err := buildGroups(ep)
+	if err != nil {
+		return nil, err
+	}
+	var targets []*Target
+	for _, g := range groups {
+		targets = append(targets, g.targets...)
+	}
+
+	if err := validateProtocol(ep.Protocol, targets); err != nil {
+		return nil, err
+	}
+
+	if err := assignTransports(ep, targets); err != nil {
+		return nil, err
+	}
+
+	if err := validateIdentityMode(ep.Identity.Mode); err != nil {
+		return nil, err
+	}
+
+	rw, err := newRewriter(ep.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := newTransformer(ep.Transform)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateCache(ep.Cache); err != nil {
+		return nil, err
+	}
+
+	matcher, err := NewMatcher(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := newMirror(ep.Mirror)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Route{
+		ID:          ep.ID,
+		Path:        ep.Path,
+		Priority:    ep.Priority,
+		matcher:     matcher,
+		basePath:    BasePath(ep.Protocol),
+		targets:     targets,
+		groups:      groups,
+		overrides:   ep.TrafficOverrides,
+		hashHeader:  ep.HashHeader,
+		healthCheck: ep.HealthCheck,
+		retry:       ep.Retry,
+		timeouts:    ep.Timeouts,
+		identity:    ep.Identity,
+		rewriter:    rw,
+		transformer: tf,
+		cache:       ep.Cache,
+		stream:      ep.Streaming,
+		mirror:      m,
+
+		maxRequestBytes:  ep.MaxRequestBytes,
+		maxResponseBytes: ep.MaxResponseBytes,
+	}
+	r.proxy = &httputil.ReverseProxy{
+		Director:       r.director,
+		Transport:      r,
+		ModifyResponse: r.modifyResponse,
+		ErrorHandler:   r.errorHandler,
+	}
+
+	return r, nil
+}
+
+// Targets returns the upstream targets of the route.
+func (r *Route) Targets() []*Target {
+	return r.targets
+}
+
+// Matcher returns the rules selecting the requests the route serves.
+func (r *Route) Matcher() *Matcher {
+	return r.matcher
+}
+
+// director rewrites the outgoing request to the target chosen in Proxy.
+func (r *Route) director(req *http.Request) {
+	a := req.Context().Value(attemptKey{}).(*attempt)
+
+	if strings.HasPrefix(req.URL.Path, r.basePath) {
+		a.suffix = r.rewriter.path(strings.TrimPrefix(req.URL.Path, r.basePath))
+	}
+	r.rewriter.request(req)
+
+	// Let the transport negotiate and undo compression of bodies we transform
+	// or cache.
+	if len(r.transformer.response) > 0 || r.cache.Enabled {
+		req.Header.Del("Accept-Encoding")
+	}
+
+	// Update the scheme, host and path of the request to match the selected target.
+	r.applyTarget(req, a)
+
+	r.applyIdentity(req)
+}
+
+// applyTarget points req at the attempt's current target.
+func (r *Route) applyTarget(req *http.Request, a *attempt) {
+	a.apply(req)
+	r.rewriter.host(req, a.target)
+}
+
+// modifyResponse enforces the response size limit, transforms the body,
+// rewrites response headers and caches the result. WebSocket, SSE and gRPC
+// bodies are passed through untouched so they keep streaming.
+func (r *Route) modifyResponse(resp *http.Response) error {
+	if isStreamingResponse(resp) {
+		r.rewriter.response(resp)
+		r.trackStream(resp)
+		return nil
+	}
+	if IsGRPCRequest(resp.Request) {
+		r.rewriter.response(resp)
+		return nil
+	}
+
+	if err := r.limitResponse(resp); err != nil {
+		return err
+	}
+	if err := r.transformer.transformResponse(resp); err != nil {
+		return err
+	}
+	r.rewriter.response(resp)
+	return r.storeResponse(resp)
+}
+
+// errorHandler answers failed upstream requests with the gateway's usual
+// JSON error shape.
+func (r *Route) errorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	a := req.Context().Value(attemptKey{}).(*attempt)
+	slog.WarnContext(req.Context(), "Upstream request failed", "route", r.Path, "target", a.target.URL.String(), "error", err)
+
+	a.failed = true
+	status, msg := errorStatus(err)
+	writeJSONError(w, status, msg)
+}
+
+// writeJSONError writes a {"error": msg} body with the given status.
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// candidates returns the targets currently allowed to receive traffic.
+func (r *Route) candidates(targets []*Target) []*Target {
+	now := time.Now()
+	available := make([]*Target, 0, len(targets))
+	for _, t := range targets {
+		if t.Available(now) {
+			available = append(available, t)
 		}
-		req.URL.Path = target.Path + newPath
 	}
+	return available
+}
 
-	return &httputil.ReverseProxy{Director: director}
+// hashKey returns the value consistent hashing is keyed on: the configured
+// header if any, otherwise the authenticated username, otherwise the client IP.
+func (r *Route) hashKey(c *gin.Context) string {
+	if r.hashHeader != "" {
+		return c.GetHeader(r.hashHeader)
+	}
+	if who, _ := caller.FromContext(c); who.User != "" {
+		return who.User
+	}
+	return c.ClientIP()
 }
 
-func ProxyToEndpoint(c *gin.Context, targetEndpoints []string) {
-	targets := []*url.URL{}
+// ContextGatewayError is the gin context key Proxy sets when the response
+// is an error of the gateway itself, e.g. an unreachable or timed-out
+// upstream, rather than the upstream's answer.
+const ContextGatewayError = "proxyGatewayError"
+
+// Gin context keys set once Admit accepted the request: that it did, and
+// the key a fresh response is cached under, if any.
+const (
+	contextAdmitted = "proxyAdmitted"
+	contextCacheKey = "proxyCacheKey"
+)
+
+// Admit runs the checks of the request that need no upstream: the body size
+// limit, the request transformation, buffering the body for retries and
+// whether any target is available. It runs ahead of quota and accounting so
+// requests the gateway refuses on its own are neither counted nor charged.
+// Cache hits are answered here too, so they are free as well.
+func (r *Route) Admit(c *gin.Context) {
+	if !r.admit(c) {
+		c.Abort()
+	}
+}
 
-	for _, target := range targetEndpoints {
-		t, err := url.Parse(target)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid target endpoint"})
-			return
+// admit performs Admit's checks once per request, answering the request and
+// returning false when it is refused or served from the cache.
+func (r *Route) admit(c *gin.Context) bool {
+	if c.GetBool(contextAdmitted) {
+		return true
+	}
+
+	if !r.limitRequest(c.Writer, c.Request) {
+		return false
+	}
+
+	cacheKey, served := r.serveCached(c)
+	if served {
+		return false
+	}
+	c.Set(contextCacheKey, cacheKey)
+
+	// Streaming request bodies, e.g. of gRPC calls, are never read ahead:
+	// the client may only finish them after the upstream answered.
+	if isStreamingRequest(c.Request) {
+		return r.admitTarget(c)
+	}
+
+	if err := r.transformer.transformRequest(c.Request); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.Is(err, errBodyTooLarge) || errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+			return false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body transformation failed", "details": err.Error()})
+		return false
+	}
+
+	if err := r.bufferBody(c.Request); err != nil {
+		status, msg := errorStatus(err)
+		if status != http.StatusRequestEntityTooLarge {
+			status, msg = http.StatusBadRequest, "Could not read request body"
 		}
+		c.JSON(status, gin.H{"error": msg})
+		return false
+	}
+
+	return r.admitTarget(c)
+}
+
+// admitTarget checks that a target can take the request.
+func (r *Route) admitTarget(c *gin.Context) bool {
+	if _, candidates := r.selectGroup(c, r.hashKey(c)); len(candidates) == 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available upstream target"})
+		return false
+	}
 
-		targets = append(targets, t)
+	c.Set(contextAdmitted, true)
+	return true
+}
+
+// Proxy forwards the request to one of the route's available targets,
+// running Admit first when the chain did not.
+func (r *Route) Proxy(c *gin.Context) {
+	if !r.admit(c) {
+		return
+	}
+
+	key := r.hashKey(c)
+	group, candidates := r.selectGroup(c, key)
+	target := pick(group.balancer, candidates, key)
+	if target == nil {
+		// The last target became unavailable since Admit.
+		c.Set(ContextGatewayError, true)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No available upstream target"})
+		return
 	}
+	a := newAttempt(group, target)
+	defer a.done()
+
+	id := identityFromContext(c)
+	recordPrimary := r.startMirror(c, id)
 
-	proxy := NewMultiTargetReverseProxy(targets)
-	proxy.ServeHTTP(c.Writer, c.Request)
+	ctx, cancel := c.Request.Context(), context.CancelFunc(func() {})
+	if isStreamingRequest(c.Request) {
+		// Long-lived connections outlive the route and server timeouts;
+		// WebSocket and SSE ones are closed when idle instead.
+		r.prepareStream(c)
+		if c.GetBool(ContextTokenSubprotocol) {
+			ctx = context.WithValue(ctx, tokenSubprotocolKey{}, true)
+		}
+	} else {
+		ctx, cancel = r.withDeadline(ctx)
+	}
+	defer cancel()
+
+	ctx = context.WithValue(ctx, attemptKey{}, a)
+	ctx = context.WithValue(ctx, identityKey{}, id)
+	ctx = context.WithValue(ctx, cacheKeyKey{}, c.GetString(contextCacheKey))
+
+	start := time.Now()
+	r.proxy.ServeHTTP(c.Writer, c.Request.WithContext(ctx))
+	if a.failed {
+		c.Set(ContextGatewayError, true)
+	}
+	if recordPrimary != nil {
+		recordPrimary(c.Writer.Status(), time.Since(start))
+	}
 }
 
 // func ProxyToEndpoint(c *gin.Context, targetEndpoint string) {
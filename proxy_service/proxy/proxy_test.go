@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"auth_service/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveChain runs Admit, a stand-in for quota and accounting, then Proxy, as
+// a custom endpoint's chain does. It returns the status and whether the
+// stand-in ran.
+func serveChain(t *testing.T, r *Route, req *http.Request) (int, bool) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	charged := false
+	w := recorder{httptest.NewRecorder()}
+	_, engine := gin.CreateTestContext(w)
+	engine.Any("/admit/*path", r.Admit, func(*gin.Context) { charged = true }, r.Proxy)
+	engine.ServeHTTP(w, req)
+	return w.Code, charged
+}
+
+func TestAdmitRefusesBeforeCharging(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	newRoute := func(ep database.CustomEndpoint) *Route {
+		ep.Path = "/admit/*path"
+		ep.Endpoints = []string{upstream.URL}
+		r, err := NewRoute(&ep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	unavailable := newRoute(database.CustomEndpoint{})
+	unavailable.Targets()[0].health.ejectedUntil = time.Now().Add(time.Hour)
+
+	unknownLength := httptest.NewRequest(http.MethodPost, "/admit/send", strings.NewReader("more than eight bytes"))
+	unknownLength.ContentLength = -1
+
+	tests := []struct {
+		name string
+		r    *Route
+		req  *http.Request
+		want int
+	}{
+		{"declared body too large", newRoute(database.CustomEndpoint{MaxRequestBytes: 8}),
+			httptest.NewRequest(http.MethodPost, "/admit/send", strings.NewReader("more than eight bytes")), http.StatusRequestEntityTooLarge},
+		{"buffered body too large", newRoute(database.CustomEndpoint{MaxRequestBytes: 8, Retry: database.RetryPolicy{Attempts: 2}}),
+			unknownLength, http.StatusRequestEntityTooLarge},
+		{"transform fails", newRoute(database.CustomEndpoint{Transform: database.Transform{Request: []database.TransformStep{{Op: TransformSet, Path: "a", Value: 1}}}}),
+			httptest.NewRequest(http.MethodPost, "/admit/send", strings.NewReader("not json")), http.StatusBadRequest},
+		{"no available target", unavailable,
+			httptest.NewRequest(http.MethodGet, "/admit/send", nil), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.req.Header.Set("Content-Type", "application/json")
+			status, charged := serveChain(t, tt.r, tt.req)
+			if status != tt.want {
+				t.Fatalf("status = %d, want %d", status, tt.want)
+			}
+			if charged {
+				t.Fatal("refused request reached accounting")
+			}
+		})
+	}
+
+	status, charged := serveChain(t, newRoute(database.CustomEndpoint{}), httptest.NewRequest(http.MethodGet, "/admit/send", nil))
+	if status != http.StatusOK || !charged {
+		t.Fatalf("admitted request: status %d, charged %v", status, charged)
+	}
+}
+
+func TestProxyFlagsGatewayErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	tests := []struct {
+		name     string
+		upstream string
+		status   int
+		flagged  bool
+	}{
+		{"upstream answers 5xx", failing.URL, http.StatusInternalServerError, false},
+		{"upstream unreachable", down.URL, http.StatusBadGateway, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewRoute(&database.CustomEndpoint{Path: "/flag/*path", Endpoints: []string{tt.upstream}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, _ := gin.CreateTestContext(recorder{httptest.NewRecorder()})
+			c.Request = httptest.NewRequest(http.MethodGet, "/flag/send", nil)
+			r.Proxy(c)
+
+			if got := c.Writer.Status(); got != tt.status || c.GetBool(ContextGatewayError) != tt.flagged {
+				t.Fatalf("got %d, flagged %v; want %d, flagged %v", got, c.GetBool(ContextGatewayError), tt.status, tt.flagged)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[uint]*Route)
+)
+
+// Register makes the route visible to the admin API and starts its active
+// health checks. Registering an endpoint again replaces its previous route
+// and stops the previous route's health checks.
+func Register(r *Route) {
+	registryMu.Lock()
+	old := registry[r.ID]
+	registry[r.ID] = r
+	registryMu.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+	r.startProbes()
+}
+
+// Routes returns all registered routes ordered by path, then ID.
+func Routes() []*Route {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	routes := make([]*Route, 0, len(registry))
+	for _, r := range registry {
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].ID < routes[j].ID
+	})
+	return routes
+}
+
+// RouteByID returns the registered route of the custom endpoint with id.
+func RouteByID(id uint) (*Route, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	r, ok := registry[id]
+	return r, ok
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"auth_service/database"
+)
+
+// probedUpstream counts the health probes it receives.
+func probedUpstream(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var probes atomic.Int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		probes.Add(1)
+	}))
+	t.Cleanup(s.Close)
+	return s, &probes
+}
+
+func probedRoute(t *testing.T, id uint, upstream string) *Route {
+	t.Helper()
+	r, err := NewRoute(&database.CustomEndpoint{
+		Path:      "/probed/*path",
+		Endpoints: []string{upstream},
+		HealthCheck: database.HealthCheck{
+			Path:     "/healthz",
+			Interval: database.Duration(5 * time.Millisecond),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.ID = id
+	return r
+}
+
+// waitProbes waits until probes exceeds n.
+func waitProbes(t *testing.T, probes *atomic.Int64, n int64) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for probes.Load() <= n {
+		if time.Now().After(deadline) {
+			t.Fatalf("no probe after %d", n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// assertStopped fails if probes keep arriving.
+func assertStopped(t *testing.T, name string, probes *atomic.Int64) {
+	t.Helper()
+	time.Sleep(20 * time.Millisecond) // Let probes in flight finish
+	before := probes.Load()
+	time.Sleep(50 * time.Millisecond)
+	if after := probes.Load(); after != before {
+		t.Fatalf("%s: %d probes after the route was stopped", name, after-before)
+	}
+}
+
+func TestRegisterStopsReplacedRouteProbes(t *testing.T) {
+	oldUpstream, oldProbes := probedUpstream(t)
+	newUpstream, newProbes := probedUpstream(t)
+
+	const id = 1_000_001
+	t.Cleanup(func() {
+		registryMu.Lock()
+		r := registry[id]
+		delete(registry, id)
+		registryMu.Unlock()
+		r.stop()
+	})
+
+	Register(probedRoute(t, id, oldUpstream.URL))
+	waitProbes(t, oldProbes, 0)
+
+	Register(probedRoute(t, id, newUpstream.URL))
+	assertStopped(t, "replaced route", oldProbes)
+
+	n := newProbes.Load()
+	waitProbes(t, newProbes, n)
+}
+
+func TestStopEndsProbes(t *testing.T) {
+	upstream, probes := probedUpstream(t)
+
+	r := probedRoute(t, 1_000_002, upstream.URL)
+	r.startProbes()
+	waitProbes(t, probes, 0)
+
+	r.stop()
+	assertStopped(t, "stopped route", probes)
+}
@@ -0,0 +1,295 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"auth_service/database"
+	"auth_service/logging"
+	"auth_service/metrics"
+	"auth_service/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Retry defaults used when the route leaves a value unset.
+const (
+	defaultRetryBackoff      = 100 * time.Millisecond
+	defaultRetryMaxBodyBytes = 1 << 20
+)
+
+// attempt tracks the target currently serving a proxied request and the
+// targets already tried, so failover never goes back to a failed target.
+type attempt struct {
+	group  *targetGroup
+	target *Target
+	suffix string // request path below the route's base path
+	tried  map[*Target]bool
+
+	// pending is true while the current target's breaker awaits the outcome
+	// of the request, so a half-open trial slot is not kept forever when the
+	// request never reaches the target.
+	pending bool
+
+	// failed is set when the gateway answered with its own error instead of
+	// an upstream response.
+	failed bool
+}
+
+// newAttempt starts serving a request with target, whose breaker slot was
+// taken by pick.
+func newAttempt(group *targetGroup, target *Target) *attempt {
+	target.acquire()
+	return &attempt{group: group, target: target, tried: map[*Target]bool{target: true}, pending: true}
+}
+
+// report feeds the outcome of the request to the current target.
+func (a *attempt) report(ctx context.Context, cfg *database.HealthCheck, o outcome) {
+	a.target.report(ctx, cfg, o)
+	a.pending = false
+}
+
+// switchTo moves the in-flight accounting from the current target to next,
+// whose breaker slot was taken by pick.
+func (a *attempt) switchTo(next *Target) {
+	a.leave()
+	next.acquire()
+	a.target = next
+	a.tried[next] = true
+	a.pending = true
+}
+
+func (a *attempt) done() {
+	a.leave()
+}
+
+// leave releases the current target, returning its breaker trial slot if no
+// outcome was reported.
+func (a *attempt) leave() {
+	a.target.release()
+	if a.pending {
+		a.target.breaker.cancel()
+		a.pending = false
+	}
+}
+
+// apply points req at the attempt's current target.
+func (a *attempt) apply(req *http.Request) {
+	req.URL.Scheme = a.target.URL.Scheme
+	req.URL.Host = a.target.URL.Host
+	req.URL.Path = a.target.URL.Path + a.suffix
+}
+
+// bufferBody makes the request body replayable when retries are enabled and
+// the body fits within the route's limit. Larger bodies are streamed as-is and
+// such requests are never retried.
+func (r *Route) bufferBody(req *http.Request) error {
+	if r.retry.Attempts <= 1 || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	limit := r.retry.MaxBodyBytes
+	if limit <= 0 {
+		limit = defaultRetryMaxBodyBytes
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, limit+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(buf)) > limit {
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), req.Body), req.Body}
+		return nil
+	}
+
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(buf))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+	return nil
+}
+
+// RoundTrip sends the request to the attempt's target and, following the
+// route's retry policy, fails over to other available targets.
+func (r *Route) RoundTrip(req *http.Request) (*http.Response, error) {
+	a := req.Context().Value(attemptKey{}).(*attempt)
+
+	for try := 1; ; try++ {
+		start := time.Now()
+		resp, err := r.tracedRoundTrip(req, a.target, try)
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		elapsed := time.Since(start)
+		metrics.ObserveUpstream(r.Path, a.target.URL.String(), status, elapsed)
+		logging.RecordUpstream(req.Context(), a.target.URL.String(), elapsed)
+		a.report(req.Context(), &r.healthCheck, outcomeOf(req, resp, err))
+
+		if try >= r.retry.Attempts || !r.retryable(req, a, resp, err) {
+			return resp, err
+		}
+
+		next := r.nextTarget(a)
+		if next == nil {
+			return resp, err
+		}
+
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				next.breaker.cancel()
+				return resp, err
+			}
+			req.Body = body
+		}
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		slog.InfoContext(req.Context(), "Retrying upstream request", "route", r.Path, "target", next.URL.String(), "attempt", try+1, "attempts", r.retry.Attempts)
+
+		backoff := r.retry.Backoff.Or(defaultRetryBackoff) << (try - 1)
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			next.breaker.cancel()
+			return nil, req.Context().Err()
+		}
+
+		a.switchTo(next)
+		r.applyTarget(req, a)
+	}
+}
+
+// tracedRoundTrip sends one attempt to t in its own client span and passes
+// the span's trace context to the upstream.
+func (r *Route) tracedRoundTrip(req *http.Request, t *Target, try int) (*http.Response, error) {
+	ctx, span := tracing.Start(req.Context(), "upstream "+r.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.Int64("gateway.route.id", int64(r.ID)),
+			attribute.String("gateway.target", t.URL.String()),
+			attribute.Int("gateway.attempt", try),
+			semconv.HTTPRequestMethodKey.String(req.Method),
+		))
+	defer span.End()
+
+	tracing.Inject(ctx, req.Header)
+	resp, err := t.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, err
+}
+
+// retryable reports whether the outcome of an attempt may be retried.
+func (r *Route) retryable(req *http.Request, a *attempt, resp *http.Response, err error) bool {
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	if hasBody && req.GetBody == nil {
+		return false
+	}
+
+	// A refused connection never reached the upstream, so any method is safe.
+	if err != nil && isDialError(err) {
+		return true
+	}
+
+	if r.retry.IdempotentOnly && !isIdempotent(req.Method) {
+		return false
+	}
+
+	if err != nil {
+		return req.Context().Err() == nil
+	}
+
+	for _, code := range r.retry.RetryOn {
+		if resp.StatusCode == code {
+			return true
+		}
+	}
+	return false
+}
+
+// nextTarget picks an available target of the attempt's group that has not
+// been tried yet and takes its breaker slot.
+func (r *Route) nextTarget(a *attempt) *Target {
+	var untried []*Target
+	for _, t := range r.candidates(a.group.targets) {
+		if !a.tried[t] {
+			untried = append(untried, t)
+		}
+	}
+	return pick(a.group.balancer, untried, "")
+}
+
+// pick chooses one of candidates with balancer and takes its breaker slot.
+// A candidate whose breaker refuses, e.g. because its half-open trials were
+// taken since it was found available, is skipped for the next one. It
+// returns nil when no candidate can be used.
+func pick(balancer Balancer, candidates []*Target, key string) *Target {
+	now := time.Now()
+	for len(candidates) > 0 {
+		t := balancer.Next(candidates, key)
+		if t.breaker.tryAcquire(now) {
+			return t
+		}
+		rest := make([]*Target, 0, len(candidates)-1)
+		for _, c := range candidates {
+			if c != t {
+				rest = append(rest, c)
+			}
+		}
+		candidates = rest
+	}
+	return nil
+}
+
+// outcomeOf classifies an attempt: 5xx answers, transport errors and timeouts
+// count against the target unless the client itself cancelled the request.
+func outcomeOf(req *http.Request, resp *http.Response, err error) outcome {
+	if err != nil {
+		if errors.Is(req.Context().Err(), context.Canceled) {
+			return outcomeIgnored
+		}
+		return outcomeFailure
+	}
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
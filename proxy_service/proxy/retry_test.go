@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"auth_service/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recorder adds the CloseNotify ReverseProxy needs from gin's writer.
+type recorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (recorder) CloseNotify() <-chan bool { return nil }
+
+func TestUnsentRequestReleasesHalfOpenTrial(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	r, err := NewRoute(&database.CustomEndpoint{
+		Path:            "/sms/*path",
+		Endpoints:       []string{upstream.URL},
+		Retry:           database.RetryPolicy{Attempts: 2},
+		CircuitBreaker:  database.CircuitBreaker{FailureRatio: 0.5, HalfOpenRequests: 1},
+		MaxRequestBytes: 8,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := r.Targets()[0]
+	target.breaker.state = BreakerOpen
+	target.breaker.openedAt = time.Now().Add(-time.Hour)
+
+	serve := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/sms/send", strings.NewReader(body))
+		req.ContentLength = -1 // Unknown length: the limit is hit while buffering
+		w := recorder{httptest.NewRecorder()}
+		c, _ := gin.CreateTestContext(w)
+		c.Request = req
+		r.Proxy(c)
+		return w.Code
+	}
+
+	// Bodies over MaxRequestBytes fail before reaching the target.
+	for i := 0; i < 3; i++ {
+		if code := serve("more than eight bytes"); code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("oversized request %d: got status %d, want 413", i, code)
+		}
+		if !target.Available(time.Now()) {
+			t.Fatalf("target unavailable after oversized request %d", i)
+		}
+	}
+
+	if code := serve("ok"); code != http.StatusOK {
+		t.Fatalf("trial request: got status %d, want 200", code)
+	}
+	if state, _ := target.breaker.snapshot(); state != BreakerClosed {
+		t.Fatalf("circuit is %s after a successful trial, want closed", state)
+	}
+}
+
+func TestHalfOpenTrialsUnderConcurrency(t *testing.T) {
+	const trials = 2
+
+	targets := newTargets(t, 1, 1)
+	for _, target := range targets {
+		target.breaker = newBreaker(database.CircuitBreaker{FailureRatio: 0.5, HalfOpenRequests: trials}, "/test", target.URL.String())
+		target.breaker.state = BreakerOpen
+		target.breaker.openedAt = time.Now().Add(-time.Hour)
+	}
+	balancer, err := NewBalancer(StrategyRoundRobin, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		taken = make(map[*Target]int)
+		start = make(chan struct{})
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if target := pick(balancer, targets, ""); target != nil {
+				mu.Lock()
+				taken[target]++
+				mu.Unlock()
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i, target := range targets {
+		if taken[target] != trials {
+			t.Errorf("target %d: %d trial requests, want %d", i, taken[target], trials)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"auth_service/database"
+)
+
+// hostTarget makes the proxy send the target's host as the Host header.
+const hostTarget = "target"
+
+type regexRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// rewriter applies a route's database.Rewrite rules with its regular
+// expressions compiled once.
+type rewriter struct {
+	cfg   database.Rewrite
+	regex []regexRewrite
+}
+
+func newRewriter(cfg database.Rewrite) (*rewriter, error) {
+	rw := &rewriter{cfg: cfg}
+	for _, rule := range cfg.Regex {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite pattern %q: %w", rule.Pattern, err)
+		}
+		// The result is sent as the path, escaped, so it cannot add a query.
+		if strings.ContainsAny(rule.Replacement, "?#") {
+			return nil, fmt.Errorf("rewrite replacement %q must be a path; set query parameters with the query rule", rule.Replacement)
+		}
+		rw.regex = append(rw.regex, regexRewrite{pattern: pattern, replacement: rule.Replacement})
+	}
+	if strings.ContainsAny(cfg.ReplacePrefix.To, "?#") {
+		return nil, fmt.Errorf("rewrite prefix %q must be a path; set query parameters with the query rule", cfg.ReplacePrefix.To)
+	}
+	return rw, nil
+}
+
+// path rewrites the request path below BaseApi.
+func (rw *rewriter) path(p string) string {
+	if rw.cfg.StripPrefix != "" {
+		p = strings.TrimPrefix(p, rw.cfg.StripPrefix)
+	}
+	if from := rw.cfg.ReplacePrefix.From; from != "" && strings.HasPrefix(p, from) {
+		p = rw.cfg.ReplacePrefix.To + strings.TrimPrefix(p, from)
+	}
+	for _, rule := range rw.regex {
+		p = rule.pattern.ReplaceAllString(p, rule.replacement)
+	}
+	if p != "" && !strings.HasPrefix(p, "/") {
+		p = "/" + p
+	}
+	return p
+}
+
+// request applies the query and header rules to the outgoing request.
+func (rw *rewriter) request(req *http.Request) {
+	if len(rw.cfg.Query) > 0 {
+		query := req.URL.Query()
+		for k, v := range rw.cfg.Query {
+			query.Set(k, v)
+		}
+		req.URL.RawQuery = query.Encode()
+	}
+	applyHeaderRules(req.Header, rw.cfg.RequestHeaders)
+}
+
+// host sets the outgoing Host header once the target is known.
+func (rw *rewriter) host(req *http.Request, target *Target) {
+	switch rw.cfg.Host {
+	case "":
+		// Keep the client's Host header.
+	case hostTarget:
+		req.Host = target.URL.Host
+	default:
+		req.Host = rw.cfg.Host
+	}
+}
+
+// response applies the header rules to the upstream response.
+func (rw *rewriter) response(resp *http.Response) {
+	applyHeaderRules(resp.Header, rw.cfg.ResponseHeaders)
+}
+
+func applyHeaderRules(h http.Header, rules database.HeaderRules) {
+	for k, v := range rules.Add {
+		h.Add(k, v)
+	}
+	for k, v := range rules.Set {
+		h.Set(k, v)
+	}
+	for _, k := range rules.Remove {
+		h.Del(k)
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"auth_service/database"
+)
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  database.Rewrite
+		path string
+		want string
+	}{
+		{"no rules", database.Rewrite{}, "/sms/send", "/sms/send"},
+		{"strip prefix", database.Rewrite{StripPrefix: "/sms"}, "/sms/send", "/send"},
+		{"strip prefix keeps leading slash", database.Rewrite{StripPrefix: "/sms/"}, "/sms/send", "/send"},
+		{"strip prefix not matching", database.Rewrite{StripPrefix: "/mail"}, "/sms/send", "/sms/send"},
+		{"strip whole path", database.Rewrite{StripPrefix: "/sms"}, "/sms", ""},
+		{
+			"replace prefix",
+			database.Rewrite{ReplacePrefix: database.PrefixRewrite{From: "/v1", To: "/api/v2"}},
+			"/v1/messages", "/api/v2/messages",
+		},
+		{
+			"regex capture",
+			database.Rewrite{Regex: []database.RegexRewrite{{Pattern: `^/users/(\d+)/sms$`, Replacement: "/sms/$1"}}},
+			"/users/42/sms", "/sms/42",
+		},
+		{
+			"regex named capture",
+			database.Rewrite{Regex: []database.RegexRewrite{{Pattern: `^/(?P<version>v\d)/(?P<rest>.*)$`, Replacement: "/${rest}/${version}"}}},
+			"/v3/messages/send", "/messages/send/v3",
+		},
+		{
+			"regex rules apply in order",
+			database.Rewrite{Regex: []database.RegexRewrite{
+				{Pattern: `^/old/`, Replacement: "/new/"},
+				{Pattern: `/new/(\w+)`, Replacement: "/$1/latest"},
+			}},
+			"/old/sms", "/sms/latest",
+		},
+		{
+			"strip before regex",
+			database.Rewrite{StripPrefix: "/gw", Regex: []database.RegexRewrite{{Pattern: `^/(\w+)`, Replacement: "/svc-$1"}}},
+			"/gw/sms/send", "/svc-sms/send",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := newRewriter(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := rw.path(tt.path); got != tt.want {
+				t.Errorf("path(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteHeaders(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules database.HeaderRules
+		in    http.Header
+		want  http.Header
+	}{
+		{
+			"add keeps existing values",
+			database.HeaderRules{Add: map[string]string{"X-Tag": "gateway"}},
+			http.Header{"X-Tag": {"client"}},
+			http.Header{"X-Tag": {"client", "gateway"}},
+		},
+		{
+			"set overrides",
+			database.HeaderRules{Set: map[string]string{"X-Tag": "gateway"}},
+			http.Header{"X-Tag": {"client", "other"}},
+			http.Header{"X-Tag": {"gateway"}},
+		},
+		{
+			"remove",
+			database.HeaderRules{Remove: []string{"cookie", "X-Debug"}},
+			http.Header{"Cookie": {"session=1"}, "X-Debug": {"1"}, "Accept": {"*/*"}},
+			http.Header{"Accept": {"*/*"}},
+		},
+		{
+			"remove runs last",
+			database.HeaderRules{Add: map[string]string{"X-Tag": "a"}, Remove: []string{"X-Tag"}},
+			http.Header{},
+			http.Header{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw, err := newRewriter(database.Rewrite{RequestHeaders: tt.rules, ResponseHeaders: tt.rules})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/sms", nil)
+			req.Header = tt.in.Clone()
+			rw.request(req)
+			if !reflect.DeepEqual(req.Header, tt.want) {
+				t.Errorf("request headers = %v, want %v", req.Header, tt.want)
+			}
+
+			resp := &http.Response{Header: tt.in.Clone()}
+			rw.response(resp)
+			if !reflect.DeepEqual(resp.Header, tt.want) {
+				t.Errorf("response headers = %v, want %v", resp.Header, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteQueryAndHost(t *testing.T) {
+	rw, err := newRewriter(database.Rewrite{Query: map[string]string{"version": "2"}, Host: hostTarget})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := NewTarget("http://sms-backend:9000/api", 1)
+
+	req := httptest.NewRequest(http.MethodGet, "http://gateway.example.com/sms?version=1&to=5", nil)
+	rw.request(req)
+	rw.host(req, target)
+
+	if got, want := req.URL.RawQuery, "to=5&version=2"; got != want {
+		t.Errorf("query = %q, want %q", got, want)
+	}
+	if got, want := req.Host, "sms-backend:9000"; got != want {
+		t.Errorf("host = %q, want %q", got, want)
+	}
+}
+
+func TestRewriteRejectsInvalidRules(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  database.Rewrite
+	}{
+		{"unclosed group", database.Rewrite{Regex: []database.RegexRewrite{{Pattern: `^/(\w+`, Replacement: "/"}}}},
+		{"bad repetition", database.Rewrite{Regex: []database.RegexRewrite{{Pattern: `*sms`, Replacement: "/"}}}},
+		{"unknown escape", database.Rewrite{Regex: []database.RegexRewrite{{Pattern: `\q`, Replacement: "/"}}}},
+		{"query in replacement", database.Rewrite{Regex: []database.RegexRewrite{{Pattern: `^/users/(\d+)$`, Replacement: "/sms?user=$1"}}}},
+		{"fragment in replacement", database.Rewrite{Regex: []database.RegexRewrite{{Pattern: `^/a$`, Replacement: "/b#c"}}}},
+		{"query in prefix", database.Rewrite{ReplacePrefix: database.PrefixRewrite{From: "/v1", To: "/v2?legacy=1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.cfg
+			cfg.Regex = append([]database.RegexRewrite{{Pattern: `^/ok`, Replacement: "/"}}, cfg.Regex...)
+			if _, err := newRewriter(cfg); err == nil {
+				t.Errorf("rewrite %+v was accepted", tt.cfg)
+			}
+
+			// The endpoint is refused as a whole.
+			_, err := NewRoute(&database.CustomEndpoint{
+				Path:      "/sms/*path",
+				Endpoints: []string{"http://sms-backend:9000"},
+				Rewrite:   cfg,
+			})
+			if err == nil {
+				t.Errorf("route with rewrite %+v was accepted", tt.cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultStreamIdleTimeout closes WebSocket and SSE connections that carried
+// no data for this long.
+const defaultStreamIdleTimeout = 5 * time.Minute
+
+// TokenSubprotocol is the WebSocket subprotocol announcing that the next
+// offered subprotocol is the caller's JWT, for browsers that cannot set an
+// Authorization header on WebSocket requests.
+const TokenSubprotocol = "bearer"
+
+// ContextTokenSubprotocol is the gin context key AuthMiddleware sets when the
+// token was taken from the offered subprotocols.
+const ContextTokenSubprotocol = "tokenSubprotocol"
+
+// tokenSubprotocolKey is the request context key marking requests whose
+// token came from the offered subprotocols.
+type tokenSubprotocolKey struct{}
+
+// StreamStats counts the long-lived connections of a route. Each connection
+// is one request to the rest of the chain, so it is charged once.
+type StreamStats struct {
+	Active   int64 `json:"active"`
+	Total    int64 `json:"total"`
+	BytesIn  int64 `json:"bytesIn"`  // Client to upstream
+	BytesOut int64 `json:"bytesOut"` // Upstream to client
+}
+
+// IsWebSocketRequest reports whether req asks to upgrade to a WebSocket.
+func IsWebSocketRequest(req *http.Request) bool {
+	return headerHasToken(req.Header, "Connection", "upgrade") && strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// IsEventStreamRequest reports whether req asks for Server-Sent Events.
+func IsEventStreamRequest(req *http.Request) bool {
+	return headerHasToken(req.Header, "Accept", "text/event-stream")
+}
+
+// isStreamingRequest reports whether req opens a long-lived connection. gRPC
+// calls count as such since any of them may stream in either direction.
+func isStreamingRequest(req *http.Request) bool {
+	return IsWebSocketRequest(req) || IsEventStreamRequest(req) || IsGRPCRequest(req)
+}
+
+func isStreamingResponse(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, field := range strings.Split(v, ",") {
+			field, _, _ = strings.Cut(field, ";")
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Streams returns the route's stream counters.
+func (r *Route) Streams() StreamStats {
+	return StreamStats{
+		Active:   atomic.LoadInt64(&r.streams.Active),
+		Total:    atomic.LoadInt64(&r.streams.Total),
+		BytesIn:  atomic.LoadInt64(&r.streams.BytesIn),
+		BytesOut: atomic.LoadInt64(&r.streams.BytesOut),
+	}
+}
+
+// prepareStream lifts the server's read and write deadlines, which would
+// otherwise cut long-lived connections.
+func (r *Route) prepareStream(c *gin.Context) {
+	rc := http.NewResponseController(c.Writer)
+	ctx := c.Request.Context()
+	if err := rc.SetReadDeadline(time.Time{}); err != nil {
+		slog.WarnContext(ctx, "Could not clear read deadline", "route", r.Path, "error", err)
+	}
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		slog.WarnContext(ctx, "Could not clear write deadline", "route", r.Path, "error", err)
+	}
+}
+
+// trackStream wraps the body of a WebSocket or SSE response so that idle
+// connections are closed and traffic is counted.
+func (r *Route) trackStream(resp *http.Response) {
+	if resp.StatusCode == http.StatusSwitchingProtocols && resp.Header.Get("Sec-WebSocket-Protocol") == "" {
+		if ok, _ := resp.Request.Context().Value(tokenSubprotocolKey{}).(bool); ok {
+			// Browsers fail the handshake unless one offered subprotocol is selected.
+			resp.Header.Set("Sec-WebSocket-Protocol", TokenSubprotocol)
+		}
+	}
+
+	s := &stream{
+		ctx:     resp.Request.Context(),
+		body:    resp.Body,
+		route:   r,
+		idle:    r.stream.IdleTimeout.Or(defaultStreamIdleTimeout),
+		started: time.Now(),
+	}
+	if id, ok := resp.Request.Context().Value(identityKey{}).(*identity); ok {
+		s.user = id.User
+	}
+	s.timer = time.AfterFunc(s.idle, func() { s.Close() })
+
+	atomic.AddInt64(&r.streams.Active, 1)
+	atomic.AddInt64(&r.streams.Total, 1)
+	resp.Body = s
+}
+
+// stream is the body of a WebSocket or SSE response. For WebSockets it is
+// the upstream connection, written to by the proxy as well.
+type stream struct {
+	ctx     context.Context // Of the request, for logging
+	body    io.ReadCloser
+	route   *Route
+	user    string
+	idle    time.Duration
+	timer   *time.Timer
+	started time.Time
+
+	bytesIn, bytesOut int64
+	closeOnce         sync.Once
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.idle)
+		atomic.AddInt64(&s.bytesOut, int64(n))
+	}
+	return n, err
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	w, ok := s.body.(io.Writer)
+	if !ok {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := w.Write(p)
+	if n > 0 {
+		s.timer.Reset(s.idle)
+		atomic.AddInt64(&s.bytesIn, int64(n))
+	}
+	return n, err
+}
+
+func (s *stream) Close() error {
+	err := s.body.Close()
+	s.closeOnce.Do(func() {
+		s.timer.Stop()
+		in, out := atomic.LoadInt64(&s.bytesIn), atomic.LoadInt64(&s.bytesOut)
+		r := s.route
+		atomic.AddInt64(&r.streams.Active, -1)
+		atomic.AddInt64(&r.streams.BytesIn, in)
+		atomic.AddInt64(&r.streams.BytesOut, out)
+		slog.InfoContext(s.ctx, "Stream closed", "route", r.Path, "user", s.user,
+			"duration", time.Since(s.started).Round(time.Second).String(), "bytes_in", in, "bytes_out", out)
+	})
+	return err
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"auth_service/database"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGRPCCallsStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The upstream answers after the first message and keeps streaming past
+	// the route's total timeout, while the client has not finished sending.
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		msg := make([]byte, 4)
+		if _, err := io.ReadFull(req.Body, msg); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Write(msg)
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}))
+	upstream.Config.Protocols = new(http.Protocols)
+	upstream.Config.Protocols.SetUnencryptedHTTP2(true)
+	upstream.Start()
+	defer upstream.Close()
+
+	r, err := NewRoute(&database.CustomEndpoint{
+		Path:      "/grpc/*path",
+		Endpoints: []string{upstream.URL},
+		Protocol:  ProtocolGRPC,
+		Retry:     database.RetryPolicy{Attempts: 3},
+		Timeouts:  database.Timeouts{Total: database.Duration(20 * time.Millisecond)},
+		Transform: database.Transform{Request: []database.TransformStep{{Op: TransformSet, Path: "a", Value: 1}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, client := io.Pipe()
+	defer client.Close()
+	req := httptest.NewRequest(http.MethodPost, "/grpc/chat.Chat/Talk", body)
+	req.Header.Set("Content-Type", "application/grpc")
+	w := recorder{httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+
+	go client.Write([]byte("ping"))
+
+	done := make(chan struct{})
+	go func() {
+		r.Proxy(c)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the gateway waited for the client to finish its stream")
+	}
+
+	if w.Code != http.StatusOK || w.Body.String() != "pingdone" {
+		t.Fatalf("got %d %q, want 200 %q", w.Code, w.Body.String(), "pingdone")
+	}
+}
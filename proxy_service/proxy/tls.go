@@ -0,0 +1,244 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"auth_service/config"
+	"auth_service/database"
+)
+
+// tlsVersions maps the accepted MinVersion settings.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// upstreamTLS holds the TLS settings of a route or target. The CA bundle
+// and client certificate are checked on every handshake and reloaded when
+// their files changed, so rotated certificates apply to new connections
+// without re-registering the route.
+type upstreamTLS struct {
+	cfg        database.UpstreamTLS
+	minVersion uint16
+
+	mu       sync.Mutex
+	roots    *x509.CertPool
+	rootsMod time.Time
+	cert     *tls.Certificate
+	certMod  time.Time
+}
+
+// newUpstreamTLS validates cfg and loads its files. It returns nil when
+// nothing is configured.
+func newUpstreamTLS(cfg database.UpstreamTLS) (*upstreamTLS, error) {
+	if cfg.IsZero() {
+		return nil, nil
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, errors.New("tls certFile and keyFile must be set together")
+	}
+
+	u := &upstreamTLS{cfg: cfg, minVersion: tls.VersionTLS12}
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown tls minVersion %q", cfg.MinVersion)
+		}
+		u.minVersion = v
+	}
+
+	if cfg.CAFile != "" {
+		if _, err := u.rootPool(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.CertFile != "" {
+		if _, err := u.clientCertificate(nil); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// clientConfig returns the tls.Config of connections to host.
+func (u *upstreamTLS) clientConfig(host string) *tls.Config {
+	c := &tls.Config{ServerName: u.cfg.ServerName, MinVersion: u.minVersion}
+	if u.cfg.CertFile != "" {
+		c.GetClientCertificate = u.clientCertificate
+	}
+	if u.cfg.CAFile != "" {
+		// Verification against the current bundle happens in verifyConnection.
+		// The expected name is fixed here: no SNI is sent to IP addresses, so
+		// the connection state cannot tell it.
+		name := u.cfg.ServerName
+		if name == "" {
+			name = host
+		}
+		c.InsecureSkipVerify = true
+		c.VerifyConnection = func(cs tls.ConnectionState) error {
+			return u.verifyConnection(cs, name)
+		}
+	}
+	return c
+}
+
+// verifyConnection verifies the upstream certificate chain against the CA
+// bundle and name.
+func (u *upstreamTLS) verifyConnection(cs tls.ConnectionState, name string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("upstream sent no certificate")
+	}
+	roots, err := u.rootPool()
+	if err != nil {
+		return err
+	}
+
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err = cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// rootPool returns the CA bundle, reloading it when the file changed. A
+// bundle that cannot be reloaded keeps the previous one in use.
+func (u *upstreamTLS) rootPool() (*x509.CertPool, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	path := tlsFile(u.cfg.CAFile)
+	mod, err := modTime(path)
+	if err == nil && u.roots != nil && mod.Equal(u.rootsMod) {
+		return u.roots, nil
+	}
+
+	if err == nil {
+		var pem []byte
+		if pem, err = os.ReadFile(path); err == nil {
+			pool := x509.NewCertPool()
+			if pool.AppendCertsFromPEM(pem) {
+				if u.roots != nil {
+					slog.Info("Reloaded upstream CA bundle", "file", path)
+				}
+				u.roots, u.rootsMod = pool, mod
+				return pool, nil
+			}
+			err = errors.New("no certificates found")
+		}
+	}
+
+	if u.roots != nil {
+		slog.Warn("Keeping previous upstream CA bundle, reload failed", "file", path, "error", err)
+		return u.roots, nil
+	}
+	return nil, fmt.Errorf("loading CA bundle %s: %w", path, err)
+}
+
+// clientCertificate returns the client certificate, reloading it when the
+// certificate or key file changed.
+func (u *upstreamTLS) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	certPath, keyPath := tlsFile(u.cfg.CertFile), tlsFile(u.cfg.KeyFile)
+	mod, err := modTime(certPath, keyPath)
+	if err == nil && u.cert != nil && mod.Equal(u.certMod) {
+		return u.cert, nil
+	}
+
+	if err == nil {
+		var cert tls.Certificate
+		if cert, err = tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+			if u.cert != nil {
+				slog.Info("Reloaded upstream client certificate", "file", certPath)
+			}
+			u.cert, u.certMod = &cert, mod
+			return u.cert, nil
+		}
+	}
+
+	if u.cert != nil {
+		slog.Warn("Keeping previous upstream client certificate, reload failed", "file", certPath, "error", err)
+		return u.cert, nil
+	}
+	return nil, fmt.Errorf("loading client certificate %s: %w", certPath, err)
+}
+
+// tlsFile resolves name under config.TLSPath. Names cannot escape it.
+func tlsFile(name string) string {
+	return filepath.Join(config.TLSPath, filepath.Clean("/"+name))
+}
+
+// modTime returns the latest modification time of paths.
+func modTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// assignTransports gives each target its upstream transport. Without TLS
+// settings the targets share one transport; otherwise each gets its own,
+// verifying the target's host name, from its TargetTLS entry or the route's
+// TLS.
+func assignTransports(ep *database.CustomEndpoint, targets []*Target) error {
+	routeTLS, err := newUpstreamTLS(ep.TLS)
+	if err != nil {
+		return err
+	}
+	shared := newTransport(ep.Timeouts, ep.Protocol, nil)
+
+	known := make(map[string]bool, len(targets))
+	byTarget := make(map[string]*upstreamTLS, len(ep.TargetTLS))
+	for rawURL, cfg := range ep.TargetTLS {
+		targetTLS, err := newUpstreamTLS(cfg)
+		if err != nil {
+			return fmt.Errorf("target %s: %w", rawURL, err)
+		}
+		byTarget[rawURL] = targetTLS
+	}
+
+	for _, t := range targets {
+		rawURL := t.URL.String()
+		known[rawURL] = true
+
+		u, ok := byTarget[rawURL]
+		if !ok {
+			u = routeTLS
+		}
+		if u == nil || t.URL.Scheme != "https" {
+			t.transport = shared
+			continue
+		}
+		t.transport = newTransport(ep.Timeouts, ep.Protocol, u.clientConfig(t.URL.Hostname()))
+	}
+
+	for rawURL := range ep.TargetTLS {
+		if !known[rawURL] {
+			return fmt.Errorf("tls settings for unknown target %s", rawURL)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"auth_service/database"
+)
+
+// Transformation operations.
+const (
+	TransformSet        = "set"
+	TransformDelete     = "delete"
+	TransformRename     = "rename"
+	TransformTemplate   = "template"
+	TransformJSONToForm = "json_to_form"
+	TransformFormToJSON = "form_to_json"
+)
+
+const (
+	defaultTransformMaxBodyBytes = 1 << 20
+
+	contentTypeJSON = "application/json"
+	contentTypeForm = "application/x-www-form-urlencoded"
+)
+
+var (
+	// errBodyTooLarge is returned when a body exceeds the transform size limit.
+	errBodyTooLarge = errors.New("body too large to transform")
+
+	// errResponseTransform wraps failures to transform an upstream response.
+	errResponseTransform = errors.New("response transformation failed")
+)
+
+// transformStep is a database.TransformStep with its template parsed.
+type transformStep struct {
+	database.TransformStep
+	tmpl *template.Template
+}
+
+// transformer applies a route's request and response transformation steps.
+type transformer struct {
+	request  []transformStep
+	response []transformStep
+	maxBytes int64
+}
+
+func newTransformer(cfg database.Transform) (*transformer, error) {
+	t := &transformer{maxBytes: cfg.MaxBodyBytes}
+	if t.maxBytes <= 0 {
+		t.maxBytes = defaultTransformMaxBodyBytes
+	}
+
+	var err error
+	if t.request, err = compileSteps(cfg.Request); err != nil {
+		return nil, err
+	}
+	if t.response, err = compileSteps(cfg.Response); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func compileSteps(steps []database.TransformStep) ([]transformStep, error) {
+	compiled := make([]transformStep, 0, len(steps))
+	for _, step := range steps {
+		s := transformStep{TransformStep: step}
+		switch step.Op {
+		case TransformSet, TransformDelete:
+			if step.Path == "" {
+				return nil, fmt.Errorf("transform %s needs a path", step.Op)
+			}
+		case TransformRename:
+			if step.Path == "" || step.To == "" {
+				return nil, errors.New("transform rename needs a path and a destination")
+			}
+		case TransformTemplate:
+			tmpl, err := template.New("body").Funcs(template.FuncMap{"json": toJSON}).Parse(step.Template)
+			if err != nil {
+				return nil, fmt.Errorf("invalid transform template: %w", err)
+			}
+			s.tmpl = tmpl
+		case TransformJSONToForm, TransformFormToJSON:
+		default:
+			return nil, fmt.Errorf("unknown transform %q", step.Op)
+		}
+		compiled = append(compiled, s)
+	}
+	return compiled, nil
+}
+
+// transformRequest rewrites the request body. Requests without steps or
+// without a body are left streaming.
+func (t *transformer) transformRequest(req *http.Request) error {
+	if len(t.request) == 0 || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	body, err := readLimited(req.Body, t.maxBytes)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	body, contentType, err := applySteps(t.request, body, req.Header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.Header.Set("Content-Type", contentType)
+	return nil
+}
+
+// transformResponse rewrites the upstream response body. Responses without
+// a body and bodies that are neither JSON nor a form, such as HTML error
+// pages, are passed through.
+func (t *transformer) transformResponse(resp *http.Response) error {
+	if len(t.response) == 0 || !hasResponseBody(resp) || !transformable(resp.Header.Get("Content-Type")) {
+		return nil
+	}
+
+	body, err := readLimited(resp.Body, t.maxBytes)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if len(body) == 0 {
+		resp.Body = http.NoBody
+		return nil
+	}
+
+	body, contentType, err := applySteps(t.response, body, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errResponseTransform, err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	resp.Header.Set("Content-Type", contentType)
+	return nil
+}
+
+// hasResponseBody reports whether resp may carry a body.
+func hasResponseBody(resp *http.Response) bool {
+	switch {
+	case resp.StatusCode < http.StatusOK,
+		resp.StatusCode == http.StatusNoContent,
+		resp.StatusCode == http.StatusNotModified:
+		return false
+	case resp.Request != nil && resp.Request.Method == http.MethodHead:
+		return false
+	}
+	return resp.ContentLength != 0 && resp.Body != nil && resp.Body != http.NoBody
+}
+
+// transformable reports whether steps can read a body of contentType: JSON,
+// including +json types, or a form for form_to_json.
+func transformable(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == contentTypeJSON || strings.HasSuffix(mediaType, "+json") || mediaType == contentTypeForm
+}
+
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+	return body, nil
+}
+
+// applySteps runs the steps over body and returns the new body and content type.
+func applySteps(steps []transformStep, body []byte, contentType string) ([]byte, string, error) {
+	for _, step := range steps {
+		var err error
+		switch step.Op {
+		case TransformSet, TransformDelete, TransformRename:
+			body, err = editJSON(body, step)
+			contentType = contentTypeJSON
+
+		case TransformTemplate:
+			var data interface{}
+			if json.Unmarshal(body, &data) != nil {
+				data = string(body)
+			}
+			var out bytes.Buffer
+			if err = step.tmpl.Execute(&out, data); err == nil {
+				body = out.Bytes()
+				contentType = step.ContentType
+				if contentType == "" {
+					contentType = contentTypeJSON
+				}
+			}
+
+		case TransformJSONToForm:
+			body, err = jsonToForm(body)
+			contentType = contentTypeForm
+
+		case TransformFormToJSON:
+			if mediaType, _, _ := mime.ParseMediaType(contentType); contentType != "" && mediaType != contentTypeForm {
+				return nil, "", fmt.Errorf("form_to_json expects %s, got %s", contentTypeForm, contentType)
+			}
+			body, err = formToJSON(body)
+			contentType = contentTypeJSON
+		}
+		if err != nil {
+			return nil, "", fmt.Errorf("transform %s: %w", step.Op, err)
+		}
+	}
+	return body, contentType, nil
+}
+
+// editJSON applies a set, delete or rename step to a JSON document.
+func editJSON(body []byte, step transformStep) ([]byte, error) {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	var err error
+	switch step.Op {
+	case TransformSet:
+		doc, err = setPath(doc, splitPath(step.Path), step.Value)
+	case TransformDelete:
+		doc = deletePath(doc, splitPath(step.Path))
+	case TransformRename:
+		value, ok := getPath(doc, splitPath(step.Path))
+		if !ok {
+			break
+		}
+		doc = deletePath(doc, splitPath(step.Path))
+		doc, err = setPath(doc, splitPath(step.To), value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+func getPath(doc interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch node := doc.(type) {
+		case map[string]interface{}:
+			v, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			doc = v
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			doc = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// setPath stores value at path, creating intermediate objects as needed.
+func setPath(doc interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	switch node := doc.(type) {
+	case []interface{}:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(node) {
+			return nil, fmt.Errorf("invalid array index %q", path[0])
+		}
+		child, err := setPath(node[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = child
+		return node, nil
+
+	case map[string]interface{}:
+		child, err := setPath(node[path[0]], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		node[path[0]] = child
+		return node, nil
+
+	case nil:
+		child, err := setPath(nil, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{path[0]: child}, nil
+
+	default:
+		return nil, fmt.Errorf("cannot set %q on a scalar value", path[0])
+	}
+}
+
+func deletePath(doc interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return doc
+	}
+	parent, ok := getPath(doc, path[:len(path)-1])
+	if !ok {
+		return doc
+	}
+
+	last := path[len(path)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		delete(node, last)
+	case []interface{}:
+		if i, err := strconv.Atoi(last); err == nil && i >= 0 && i < len(node) {
+			updated := append(node[:i:i], node[i+1:]...)
+			if len(path) == 1 {
+				return updated
+			}
+			doc, _ = setPath(doc, path[:len(path)-1], updated)
+		}
+	}
+	return doc
+}
+
+// jsonToForm encodes a flat JSON object as a form; nested values are sent as JSON.
+func jsonToForm(body []byte) ([]byte, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal(body, &obj); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	for k, v := range obj {
+		switch value := v.(type) {
+		case string:
+			form.Set(k, value)
+		case []interface{}:
+			for _, item := range value {
+				form.Add(k, formValue(item))
+			}
+		default:
+			form.Set(k, formValue(value))
+		}
+	}
+	return []byte(form.Encode()), nil
+}
+
+func formValue(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return toJSON(v)
+}
+
+// formToJSON decodes a form into a JSON object; repeated keys become arrays.
+func formToJSON(body []byte) ([]byte, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	obj := make(map[string]interface{}, len(form))
+	for k, values := range form {
+		if len(values) == 1 {
+			obj[k] = values[0]
+		} else {
+			obj[k] = values
+		}
+	}
+	return json.Marshal(obj)
+}
+
+// toJSON is exposed to templates as "json".
+func toJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "null"
+	}
+	return string(b)
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"auth_service/database"
+)
+
+func TestTransformResponsePassesThrough(t *testing.T) {
+	tf, err := newTransformer(database.Transform{Response: []database.TransformStep{{Op: TransformDelete, Path: "secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"no content", http.MethodDelete, http.StatusNoContent, "", ""},
+		{"not modified", http.MethodGet, http.StatusNotModified, contentTypeJSON, ""},
+		{"head", http.MethodHead, http.StatusOK, contentTypeJSON, ""},
+		{"empty json body", http.MethodGet, http.StatusInternalServerError, contentTypeJSON, ""},
+		{"html error page", http.MethodGet, http.StatusBadGateway, "text/html", "<h1>Bad Gateway</h1>"},
+		{"plain text", http.MethodGet, http.StatusOK, "text/plain; charset=utf-8", "{not json"},
+		{"no content type", http.MethodGet, http.StatusOK, "", `{"secret":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode:    tt.status,
+				Header:        http.Header{},
+				Body:          io.NopCloser(strings.NewReader(tt.body)),
+				ContentLength: -1,
+				Request:       &http.Request{Method: tt.method},
+			}
+			if tt.contentType != "" {
+				resp.Header.Set("Content-Type", tt.contentType)
+			}
+
+			if err := tf.transformResponse(resp); err != nil {
+				t.Fatalf("transformResponse: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestTransformResponseJSON(t *testing.T) {
+	tf, err := newTransformer(database.Transform{Response: []database.TransformStep{{Op: TransformDelete, Path: "secret"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contentType := range []string{"application/json; charset=utf-8", "application/problem+json"} {
+		resp := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {contentType}},
+			Body:          io.NopCloser(strings.NewReader(`{"id":1,"secret":"x"}`)),
+			ContentLength: -1,
+			Request:       &http.Request{Method: http.MethodGet},
+		}
+		if err := tf.transformResponse(resp); err != nil {
+			t.Fatalf("%s: transformResponse: %v", contentType, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if got, want := string(body), `{"id":1}`; got != want {
+			t.Errorf("%s: body = %s, want %s", contentType, got, want)
+		}
+	}
+}
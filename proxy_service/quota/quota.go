@@ -0,0 +1,250 @@
+// Package quota enforces plan-based call caps on custom endpoints. Quotas are
+// counted per user in calendar windows (days or months in
+// config.QuotaLocation), independently of the monetary balance kept by the
+// accounting service.
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"auth_service/config"
+	"auth_service/database"
+
+	"gorm.io/gorm"
+)
+
+// Quota periods.
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// Quota scopes, as reported in Status.
+const (
+	ScopeUser = "user"
+	ScopeRole = "role"
+)
+
+const consumeSQL = `
+INSERT INTO quota_usages (quota_id, username, window_start, count)
+VALUES (@quota, @username, @start, 1)
+ON CONFLICT (quota_id, username, window_start) DO UPDATE SET count = quota_usages.count + 1
+WHERE quota_usages.count < @limit
+RETURNING count`
+
+// Status is a user's standing against one quota in the current window.
+type Status struct {
+	ID        uint      `json:"id"`
+	Endpoint  string    `json:"endpoint"`
+	Period    string    `json:"period"`
+	Scope     string    `json:"scope"`
+	Limit     int64     `json:"limit"`
+	Used      int64     `json:"used"`
+	Remaining int64     `json:"remaining"`
+	ResetsAt  time.Time `json:"resetsAt"`
+}
+
+// ExceededError is returned by Consume when a quota has no calls left.
+type ExceededError struct {
+	Status Status
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s quota of %d calls to %s exceeded", e.Status.Period, e.Status.Limit, e.Status.Endpoint)
+}
+
+// EndpointKey returns the endpoint quotas of the custom endpoint at path are
+// defined for, i.e. the path without its "/*path" wildcard.
+func EndpointKey(path string) string {
+	return strings.TrimSuffix(path, "/*path")
+}
+
+// ValidPeriod reports whether period is a supported quota period.
+func ValidPeriod(period string) bool {
+	return period == PeriodDaily || period == PeriodMonthly
+}
+
+// Window returns the calendar window of period that contains t.
+func Window(period string, t time.Time) (start, end time.Time) {
+	t = t.In(config.QuotaLocation)
+	if period == PeriodMonthly {
+		start = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+		return start, start.AddDate(0, 1, 0)
+	}
+	start = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return start, start.AddDate(0, 0, 1)
+}
+
+// definitions caches the quota table for config.QuotaCacheTTL. It is small
+// and rarely changes, so requests find their quotas without a query.
+var definitions struct {
+	mu       sync.Mutex
+	quotas   []database.Quota // Ordered by endpoint and period
+	loadedAt time.Time
+}
+
+// Invalidate drops the cached quota definitions after they changed.
+func Invalidate() {
+	definitions.mu.Lock()
+	definitions.loadedAt = time.Time{}
+	definitions.mu.Unlock()
+}
+
+// all returns every quota definition, reading them again once the cache
+// expired. The result must not be modified.
+func all(ctx context.Context) ([]database.Quota, error) {
+	definitions.mu.Lock()
+	defer definitions.mu.Unlock()
+
+	if !definitions.loadedAt.IsZero() && time.Since(definitions.loadedAt) < config.QuotaCacheTTL {
+		return definitions.quotas, nil
+	}
+
+	var quotas []database.Quota
+	if err := database.DB.WithContext(ctx).Order("endpoint, period").Find(&quotas).Error; err != nil {
+		return nil, err
+	}
+	definitions.quotas = quotas
+	definitions.loadedAt = time.Now()
+	return quotas, nil
+}
+
+// Applicable returns the quotas that bind username, whose role is role, on
+// endpoint, or on every endpoint when endpoint is empty. A user's own quota
+// replaces the role's quota for the same endpoint and period.
+func Applicable(ctx context.Context, endpoint, username, role string) ([]database.Quota, error) {
+	defined, err := all(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var quotas []database.Quota
+	for _, q := range defined {
+		if endpoint != "" && q.Endpoint != endpoint {
+			continue
+		}
+		if (username != "" && q.Username == username) || (role != "" && q.Role == role && q.Username == "") {
+			quotas = append(quotas, q)
+		}
+	}
+
+	type scope struct{ endpoint, period string }
+	own := make(map[scope]bool)
+	for _, q := range quotas {
+		if q.Username != "" {
+			own[scope{q.Endpoint, q.Period}] = true
+		}
+	}
+
+	applicable := quotas[:0]
+	for _, q := range quotas {
+		if q.Username == "" && own[scope{q.Endpoint, q.Period}] {
+			continue
+		}
+		applicable = append(applicable, q)
+	}
+	return applicable, nil
+}
+
+// Consume counts one call by username against each quota. When any quota
+// is exhausted nothing is counted and an *ExceededError is returned.
+func Consume(ctx context.Context, quotas []database.Quota, username string, now time.Time) ([]Status, error) {
+	statuses := make([]Status, 0, len(quotas))
+	err := database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, q := range quotas {
+			start, end := Window(q.Period, now)
+			status := newStatus(q, end)
+
+			var counted []int64
+			if q.Limit > 0 {
+				err := tx.Raw(consumeSQL, map[string]interface{}{
+					"quota":    q.ID,
+					"username": username,
+					"start":    start,
+					"limit":    q.Limit,
+				}).Scan(&counted).Error
+				if err != nil {
+					return err
+				}
+			}
+			if len(counted) == 0 {
+				status.Used, status.Remaining = q.Limit, 0
+				return &ExceededError{Status: status}
+			}
+
+			if counted[0] == 1 {
+				// First call of a new window: drop the finished ones.
+				tx.Where("quota_id = ? AND username = ? AND window_start < ?", q.ID, username, start).Delete(&database.QuotaUsage{})
+			}
+			status.Used = counted[0]
+			status.Remaining = q.Limit - counted[0]
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// Refund gives back a call previously counted by Consume at now.
+func Refund(ctx context.Context, quotas []database.Quota, username string, now time.Time) error {
+	return database.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, q := range quotas {
+			start, _ := Window(q.Period, now)
+			err := tx.Model(&database.QuotaUsage{}).
+				Where("quota_id = ? AND username = ? AND window_start = ? AND count > 0", q.ID, username, start).
+				Update("count", gorm.Expr("count - 1")).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Usage reports username's standing against each quota without counting a call.
+func Usage(ctx context.Context, quotas []database.Quota, username string, now time.Time) ([]Status, error) {
+	statuses := make([]Status, 0, len(quotas))
+	for _, q := range quotas {
+		start, end := Window(q.Period, now)
+		status := newStatus(q, end)
+
+		var usage database.QuotaUsage
+		err := database.DB.WithContext(ctx).
+			Where("quota_id = ? AND username = ? AND window_start = ?", q.ID, username, start).
+			First(&usage).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+
+		status.Used = usage.Count
+		status.Remaining = q.Limit - usage.Count
+		if status.Remaining < 0 {
+			status.Remaining = 0
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func newStatus(q database.Quota, resetsAt time.Time) Status {
+	scope := ScopeRole
+	if q.Username != "" {
+		scope = ScopeUser
+	}
+	return Status{
+		ID:       q.ID,
+		Endpoint: q.Endpoint,
+		Period:   q.Period,
+		Scope:    scope,
+		Limit:    q.Limit,
+		ResetsAt: resetsAt,
+	}
+}
@@ -0,0 +1,160 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"auth_service/config"
+	"auth_service/database"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func init() {
+	if config.QuotaLocation == nil {
+		config.QuotaLocation = time.UTC
+	}
+}
+
+func TestWindow(t *testing.T) {
+	now := time.Date(2024, time.February, 29, 23, 59, 0, 0, time.UTC)
+	tests := []struct {
+		period     string
+		start, end time.Time
+	}{
+		{PeriodDaily, time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC), time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+		{PeriodMonthly, time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		start, end := Window(tt.period, now)
+		if !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("%s: got [%s, %s), want [%s, %s)", tt.period, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+// useTestDatabase points database.DB at the database named by
+// TEST_DATABASE_DSN, e.g. "host=127.0.0.1 user=postgres password=postgres
+// dbname=test", and skips the test when it is not set.
+func useTestDatabase(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&database.Quota{}, &database.QuotaUsage{}); err != nil {
+		t.Fatal(err)
+	}
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() { database.DB = previous })
+}
+
+// testQuota creates a quota on an endpoint no earlier run used.
+func testQuota(t *testing.T, period string, limit int64) database.Quota {
+	t.Helper()
+	q := database.Quota{
+		Endpoint: "/test/" + t.Name() + "/" + strconv.FormatInt(time.Now().UnixNano(), 10),
+		Username: "alice",
+		Period:   period,
+		Limit:    limit,
+	}
+	if err := database.DB.Create(&q).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.DB.Where("quota_id = ?", q.ID).Delete(&database.QuotaUsage{})
+		database.DB.Unscoped().Delete(&q)
+	})
+	return q
+}
+
+func TestConsumeAndRefund(t *testing.T) {
+	useTestDatabase(t)
+	ctx := context.Background()
+	now := time.Now()
+	quotas := []database.Quota{testQuota(t, PeriodDaily, 2)}
+
+	for i := 1; i <= 2; i++ {
+		statuses, err := Consume(ctx, quotas, "alice", now)
+		if err != nil {
+			t.Fatalf("call %d: %v", i, err)
+		}
+		if statuses[0].Used != int64(i) || statuses[0].Remaining != int64(2-i) {
+			t.Fatalf("call %d: %+v", i, statuses[0])
+		}
+	}
+
+	var exceeded *ExceededError
+	if _, err := Consume(ctx, quotas, "alice", now); !errors.As(err, &exceeded) {
+		t.Fatalf("third call: got %v, want *ExceededError", err)
+	}
+
+	if err := Refund(ctx, quotas, "alice", now); err != nil {
+		t.Fatal(err)
+	}
+	if statuses, err := Consume(ctx, quotas, "alice", now); err != nil || statuses[0].Remaining != 0 {
+		t.Fatalf("call after refund: %+v, %v", statuses, err)
+	}
+
+	// Another window starts from zero.
+	if statuses, err := Consume(ctx, quotas, "alice", now.AddDate(0, 0, 1)); err != nil || statuses[0].Used != 1 {
+		t.Fatalf("call next day: %+v, %v", statuses, err)
+	}
+}
+
+func TestConsumeCountsNothingWhenAnyQuotaIsExhausted(t *testing.T) {
+	useTestDatabase(t)
+	ctx := context.Background()
+	now := time.Now()
+	daily, monthly := testQuota(t, PeriodDaily, 5), testQuota(t, PeriodMonthly, 1)
+	quotas := []database.Quota{daily, monthly}
+
+	if _, err := Consume(ctx, quotas, "alice", now); err != nil {
+		t.Fatal(err)
+	}
+	var exceeded *ExceededError
+	if _, err := Consume(ctx, quotas, "alice", now); !errors.As(err, &exceeded) || exceeded.Status.ID != monthly.ID {
+		t.Fatalf("got %v, want the monthly quota exceeded", err)
+	}
+
+	statuses, err := Usage(ctx, quotas, "alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].Used != 1 {
+		t.Fatalf("daily quota counted the refused call: %+v", statuses[0])
+	}
+}
+
+func TestRefundNeverGoesNegative(t *testing.T) {
+	useTestDatabase(t)
+	ctx := context.Background()
+	now := time.Now()
+	quotas := []database.Quota{testQuota(t, PeriodDaily, 1)}
+
+	if _, err := Consume(ctx, quotas, "alice", now); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := Refund(ctx, quotas, "alice", now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	statuses, err := Usage(ctx, quotas, "alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if statuses[0].Used != 0 {
+		t.Fatalf("got %+v, want no calls used", statuses[0])
+	}
+}
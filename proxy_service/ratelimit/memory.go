@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// pruneEvery is how many calls pass between sweeps of idle entries.
+const pruneEvery = 10000
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time // When the bucket has refilled, so dropping it changes nothing
+}
+
+type window struct {
+	start             time.Time
+	previous, current int
+	expiresAt         time.Time // When neither count weighs on the limit any more
+}
+
+// MemoryStore keeps limits in process. Limits are per replica.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	windows map[string]*window
+	calls   int
+	now     func() time.Time
+}
+
+// NewMemoryStore returns an empty in-process store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		windows: make(map[string]*window),
+		now:     time.Now,
+	}
+}
+
+// Allow implements Store.
+func (s *MemoryStore) Allow(_ context.Context, key string, limit Limit) (Decision, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.calls%pruneEvery == 0 {
+		s.prune(now)
+	}
+
+	if limit.Algorithm == AlgorithmSlidingWindow {
+		return s.slidingWindow(key, limit, now), nil
+	}
+	return s.tokenBucket(key, limit, now), nil
+}
+
+func (s *MemoryStore) tokenBucket(key string, limit Limit, now time.Time) Decision {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: limit.burst(), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	b.tokens = math.Min(limit.burst(), b.tokens+now.Sub(b.updatedAt).Seconds()*limit.rate())
+	b.updatedAt = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	b.fullAt = now.Add(seconds((limit.burst() - b.tokens) / limit.rate()))
+	return tokenBucketDecision(limit, b.tokens, allowed)
+}
+
+func (s *MemoryStore) slidingWindow(key string, limit Limit, now time.Time) Decision {
+	start := now.Truncate(limit.Period)
+
+	w, ok := s.windows[key]
+	if !ok {
+		w = &window{start: start}
+		s.windows[key] = w
+	}
+
+	switch {
+	case w.start.Equal(start):
+	case w.start.Add(limit.Period).Equal(start):
+		w.previous, w.current = w.current, 0
+		w.start = start
+	default:
+		w.previous, w.current = 0, 0
+		w.start = start
+	}
+
+	// Past the end of the next window both counts are reset anyway.
+	w.expiresAt = w.start.Add(2 * limit.Period)
+
+	allowed := slidingWindowAllows(limit, now, w.previous, w.current)
+	if allowed {
+		w.current++
+	}
+	return slidingWindowDecision(limit, now, w.previous, w.current, allowed)
+}
+
+// prune drops the entries that no longer hold anything back, each against
+// its own limit's period: full buckets and windows whose counts have slid
+// out. Callers must hold s.mu.
+func (s *MemoryStore) prune(now time.Time) {
+	for key, b := range s.buckets {
+		if !now.Before(b.fullAt) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, w := range s.windows {
+		if !now.Before(w.expiresAt) {
+			delete(s.windows, key)
+		}
+	}
+}
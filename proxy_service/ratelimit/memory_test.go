@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// clock is a manually advanced time source.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestStore returns a memory store whose clock starts at a window boundary
+// of every period the tests use.
+func newTestStore() (*MemoryStore, *clock) {
+	c := &clock{t: time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)}
+	s := NewMemoryStore()
+	s.now = c.now
+	return s, c
+}
+
+func allow(t *testing.T, s *MemoryStore, key string, limit Limit) Decision {
+	t.Helper()
+	d, err := s.Allow(context.Background(), key, limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestMemoryTokenBucket(t *testing.T) {
+	s, clk := newTestStore()
+	limit := Limit{Algorithm: AlgorithmTokenBucket, Requests: 2, Period: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		if d := allow(t, s, "k", limit); !d.Allowed || d.Remaining != 1-i {
+			t.Fatalf("request %d: %+v", i, d)
+		}
+	}
+	d := allow(t, s, "k", limit)
+	if d.Allowed || d.RetryAfter != 30*time.Second || d.Reset != time.Minute {
+		t.Fatalf("request over the limit: %+v", d)
+	}
+
+	clk.advance(30 * time.Second)
+	if d := allow(t, s, "k", limit); !d.Allowed {
+		t.Fatalf("request after one refill: %+v", d)
+	}
+	if d := allow(t, s, "other", limit); !d.Allowed || d.Remaining != 1 {
+		t.Fatalf("keys share a bucket: %+v", d)
+	}
+}
+
+func TestMemorySlidingWindowRollover(t *testing.T) {
+	s, clk := newTestStore()
+	limit := Limit{Algorithm: AlgorithmSlidingWindow, Requests: 4, Period: time.Minute}
+
+	for i := 0; i < 4; i++ {
+		if d := allow(t, s, "k", limit); !d.Allowed {
+			t.Fatalf("request %d: %+v", i, d)
+		}
+	}
+	if d := allow(t, s, "k", limit); d.Allowed || d.RetryAfter != time.Minute {
+		t.Fatalf("request over the limit: %+v", d)
+	}
+
+	// Halfway through the next window half of the previous count remains.
+	clk.advance(90 * time.Second)
+	for i := 0; i < 2; i++ {
+		if d := allow(t, s, "k", limit); !d.Allowed {
+			t.Fatalf("request %d after rollover: %+v", i, d)
+		}
+	}
+	if d := allow(t, s, "k", limit); d.Allowed {
+		t.Fatalf("request over the slid limit: %+v", d)
+	}
+
+	// Two windows later nothing weighs on the limit.
+	clk.advance(2 * time.Minute)
+	if d := allow(t, s, "k", limit); !d.Allowed || d.Remaining != 3 {
+		t.Fatalf("request after two windows: %+v", d)
+	}
+}
+
+func TestMemoryPruneKeepsLongPeriodLimits(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		name  string
+		limit Limit
+	}{
+		{"token bucket", Limit{Algorithm: AlgorithmTokenBucket, Requests: 2, Period: day}},
+		{"sliding window", Limit{Algorithm: AlgorithmSlidingWindow, Requests: 2, Period: day}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clk := newTestStore()
+			allow(t, s, "daily", tt.limit)
+			allow(t, s, "daily", tt.limit)
+
+			// A sweep triggered by a short limit must not reset the daily one.
+			clk.advance(2 * time.Hour)
+			allow(t, s, "minutely", Limit{Algorithm: tt.limit.Algorithm, Requests: 1, Period: time.Minute})
+			s.mu.Lock()
+			s.prune(clk.now())
+			s.mu.Unlock()
+
+			if d := allow(t, s, "daily", tt.limit); d.Allowed {
+				t.Fatalf("daily limit reset after a sweep: %+v", d)
+			}
+		})
+	}
+}
+
+func TestMemoryPruneDropsSpentEntries(t *testing.T) {
+	s, clk := newTestStore()
+	allow(t, s, "bucket", Limit{Algorithm: AlgorithmTokenBucket, Requests: 2, Period: time.Minute})
+	allow(t, s, "window", Limit{Algorithm: AlgorithmSlidingWindow, Requests: 2, Period: time.Minute})
+
+	clk.advance(2 * time.Minute)
+	s.mu.Lock()
+	s.prune(clk.now())
+	s.mu.Unlock()
+
+	if len(s.buckets) != 0 || len(s.windows) != 0 {
+		t.Fatalf("%d buckets and %d windows left, want none", len(s.buckets), len(s.windows))
+	}
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// refillExpr is the token count of an existing bucket after refilling it.
+const refillExpr = "LEAST(@burst, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM (now() - rate_limit_buckets.updated_at)) * @rate)"
+
+// takeExpr is the token count of an existing bucket after this request.
+const takeExpr = "CASE WHEN {refill} >= 1 THEN {refill} - 1 ELSE {refill} END"
+
+// full_at is when the bucket has refilled, after which dropping it changes
+// nothing.
+var tokenBucketSQL = strings.NewReplacer("{take}", takeExpr, "{refill}", refillExpr).Replace(`
+INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at, full_at)
+VALUES (@key, @burst - 1, true, now(), now() + make_interval(secs => 1 / @rate))
+ON CONFLICT (key) DO UPDATE SET
+	tokens = {take},
+	allowed = {refill} >= 1,
+	updated_at = now(),
+	full_at = now() + make_interval(secs => (@burst - ({take})) / @rate)
+RETURNING tokens, allowed`)
+
+const incrementWindowSQL = `
+INSERT INTO rate_limit_windows (key, window_start, count)
+VALUES (@key, @start, 1)
+ON CONFLICT (key, window_start) DO UPDATE SET count = rate_limit_windows.count + 1
+RETURNING count`
+
+// PostgresStore keeps limits in the shared database (see
+// database.RateLimitBucket and database.RateLimitWindow) so they hold
+// across all gateway replicas.
+type PostgresStore struct {
+	db    *gorm.DB
+	calls uint64
+}
+
+// NewPostgresStore returns a store backed by db.
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Allow implements Store.
+func (s *PostgresStore) Allow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	if atomic.AddUint64(&s.calls, 1)%pruneEvery == 0 {
+		s.db.WithContext(ctx).Exec("DELETE FROM rate_limit_buckets WHERE full_at <= now()")
+	}
+
+	if limit.Algorithm == AlgorithmSlidingWindow {
+		return s.slidingWindow(ctx, key, limit)
+	}
+	return s.tokenBucket(ctx, key, limit)
+}
+
+func (s *PostgresStore) tokenBucket(ctx context.Context, key string, limit Limit) (Decision, error) {
+	var row struct {
+		Tokens  float64
+		Allowed bool
+	}
+	err := s.db.WithContext(ctx).Raw(tokenBucketSQL, map[string]interface{}{
+		"key":   key,
+		"burst": limit.burst(),
+		"rate":  limit.rate(),
+	}).Scan(&row).Error
+	if err != nil {
+		return Decision{}, err
+	}
+	return tokenBucketDecision(limit, row.Tokens, row.Allowed), nil
+}
+
+func (s *PostgresStore) slidingWindow(ctx context.Context, key string, limit Limit) (Decision, error) {
+	now := time.Now().UTC()
+	start := now.Truncate(limit.Period)
+	previousStart := start.Add(-limit.Period)
+	db := s.db.WithContext(ctx)
+
+	// Count the request first and take it back if it does not fit; this
+	// keeps concurrent replicas from all slipping through at the boundary.
+	var current int
+	if err := db.Raw(incrementWindowSQL, map[string]interface{}{"key": key, "start": start}).Scan(&current).Error; err != nil {
+		return Decision{}, err
+	}
+	if current == 1 {
+		db.Exec("DELETE FROM rate_limit_windows WHERE key = ? AND window_start < ?", key, previousStart)
+	}
+
+	var previous int
+	if err := db.Raw("SELECT count FROM rate_limit_windows WHERE key = ? AND window_start = ?", key, previousStart).Scan(&previous).Error; err != nil {
+		return Decision{}, err
+	}
+
+	allowed := slidingWindowAllows(limit, now, previous, current-1)
+	if !allowed {
+		current--
+		if err := db.Exec("UPDATE rate_limit_windows SET count = count - 1 WHERE key = ? AND window_start = ?", key, start).Error; err != nil {
+			return Decision{}, err
+		}
+	}
+	return slidingWindowDecision(limit, now, previous, current, allowed), nil
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"auth_service/database"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// newTestPostgresStore connects to the database named by TEST_DATABASE_DSN,
+// e.g. "host=127.0.0.1 user=postgres password=postgres dbname=test", and
+// skips the test when it is not set.
+func newTestPostgresStore(t *testing.T) (*PostgresStore, *gorm.DB) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&database.RateLimitBucket{}, &database.RateLimitWindow{}); err != nil {
+		t.Fatal(err)
+	}
+	return NewPostgresStore(db), db
+}
+
+// testKey returns a key no earlier run used.
+func testKey(t *testing.T) string {
+	return "test:" + t.Name() + ":" + strconv.FormatInt(time.Now().UnixNano(), 10)
+}
+
+func TestPostgresTokenBucket(t *testing.T) {
+	s, db := newTestPostgresStore(t)
+	key := testKey(t)
+	limit := Limit{Algorithm: AlgorithmTokenBucket, Requests: 2, Period: 24 * time.Hour}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if d, err := s.Allow(ctx, key, limit); err != nil || !d.Allowed {
+			t.Fatalf("request %d: %+v, %v", i, d, err)
+		}
+	}
+	d, err := s.Allow(ctx, key, limit)
+	if err != nil || d.Allowed || d.RetryAfter <= 0 {
+		t.Fatalf("request over the limit: %+v, %v", d, err)
+	}
+
+	// Pruning keeps a daily bucket that has not refilled.
+	if err := db.Exec("DELETE FROM rate_limit_buckets WHERE full_at <= now()").Error; err != nil {
+		t.Fatal(err)
+	}
+	if d, err := s.Allow(ctx, key, limit); err != nil || d.Allowed {
+		t.Fatalf("request after pruning: %+v, %v", d, err)
+	}
+}
+
+func TestPostgresSlidingWindow(t *testing.T) {
+	s, _ := newTestPostgresStore(t)
+	key := testKey(t)
+	limit := Limit{Algorithm: AlgorithmSlidingWindow, Requests: 2, Period: 24 * time.Hour}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if d, err := s.Allow(ctx, key, limit); err != nil || !d.Allowed {
+			t.Fatalf("request %d: %+v, %v", i, d, err)
+		}
+	}
+	if d, err := s.Allow(ctx, key, limit); err != nil || d.Allowed || d.RetryAfter <= 0 {
+		t.Fatalf("request over the limit: %+v, %v", d, err)
+	}
+}
@@ -0,0 +1,122 @@
+// Package ratelimit implements token-bucket and sliding-window rate limits
+// over pluggable stores, so limits can be kept in process or shared by all
+// gateway replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Supported algorithms.
+const (
+	AlgorithmTokenBucket   = "token_bucket"
+	AlgorithmSlidingWindow = "sliding_window"
+)
+
+// Limit is a resolved rate limit.
+type Limit struct {
+	Algorithm string
+	Requests  int
+	Period    time.Duration
+	Burst     int // token_bucket only; defaults to Requests
+}
+
+// Validate reports configuration errors in the limit.
+func (l Limit) Validate() error {
+	switch l.Algorithm {
+	case AlgorithmTokenBucket, AlgorithmSlidingWindow:
+	default:
+		return fmt.Errorf("unknown rate limit algorithm %q", l.Algorithm)
+	}
+	if l.Requests <= 0 || l.Period <= 0 {
+		return fmt.Errorf("rate limit needs positive requests and period")
+	}
+	return nil
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.Requests)
+}
+
+// rate is the token refill rate per second.
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Decision is the outcome of a single request against a limit.
+type Decision struct {
+	Allowed    bool
+	Limit      int           // Requests allowed per period (or burst for token buckets)
+	Remaining  int           // Requests still allowed right now
+	Reset      time.Duration // Time until the limit is fully replenished
+	RetryAfter time.Duration // Time until the next request may succeed, when denied
+}
+
+// Store consumes requests from the limit identified by key.
+type Store interface {
+	Allow(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// tokenBucketDecision builds the decision once the bucket holds tokens
+// after refilling, and whether one of them was taken.
+func tokenBucketDecision(limit Limit, tokens float64, allowed bool) Decision {
+	rate := limit.rate()
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     int(limit.burst()),
+		Remaining: int(math.Floor(tokens)),
+		Reset:     seconds((limit.burst() - tokens) / rate),
+	}
+	if !allowed {
+		d.RetryAfter = seconds((1 - tokens) / rate)
+	}
+	return d
+}
+
+// slidingWindowDecision estimates the requests in the sliding window from the
+// counts of the previous and current fixed windows. current already includes
+// the request being decided when allowed is true.
+func slidingWindowDecision(limit Limit, now time.Time, previous, current int, allowed bool) Decision {
+	elapsed := now.Sub(now.Truncate(limit.Period))
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	estimate := float64(previous)*weight + float64(current)
+
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Max(0, math.Floor(float64(limit.Requests)-estimate))),
+		Reset:     limit.Period - elapsed,
+	}
+	if !allowed {
+		d.RetryAfter = d.Reset
+		if current < limit.Requests && previous > 0 {
+			// Wait until enough of the previous window has slid out.
+			needed := 1 - float64(limit.Requests-1-current)/float64(previous)
+			wait := time.Duration(needed*float64(limit.Period)) - elapsed
+			if wait > 0 && wait < d.RetryAfter {
+				d.RetryAfter = wait
+			}
+		}
+	}
+	return d
+}
+
+// slidingWindowAllows reports whether one more request fits the window.
+func slidingWindowAllows(limit Limit, now time.Time, previous, current int) bool {
+	elapsed := now.Sub(now.Truncate(limit.Period))
+	weight := 1 - float64(elapsed)/float64(limit.Period)
+	return float64(previous)*weight+float64(current)+1 <= float64(limit.Requests)
+}
+
+func seconds(s float64) time.Duration {
+	if s < 0 {
+		return 0
+	}
+	return time.Duration(s * float64(time.Second))
+}
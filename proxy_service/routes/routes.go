@@ -4,11 +4,8 @@ import (
 	"auth_service/config"
 	"auth_service/handlers"
 	"auth_service/middleware"
-	"log"
+	"auth_service/tracing"
 
-	// "fmt"
-	// "net"
-	// "strings"
 	"net/http"
 
 	"github.com/dchest/captcha"
@@ -37,30 +34,15 @@ func CaptchaCorsMiddleware() gin.HandlerFunc {
 	}
 }
 
-// SetupRoutes configures and returns the Gin engine.
-func SetupRoutes(httpAddr, httpsAddr string) {
-	httpsRouter := gin.Default()
-	// httpRouter := gin.Default()
-
-	// Enable CORS for frontend requests.
-	corsConfig := cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * 60 * 60, // 12 hours
-	}
-
-	httpsRouter.Use(cors.New(corsConfig))
-	// httpRouter.Use(cors.New(corsConfig))
-
+// registerCaptchaRoutes adds the captcha endpoints, with their explicit CORS
+// handling, to r. They are served on the plain HTTP listener too.
+func registerCaptchaRoutes(r *gin.Engine) {
 	// Create a separate group for captcha endpoints with explicit CORS
-	captchaGroup := httpsRouter.Group("/captcha")
+	captchaGroup := r.Group("/captcha")
 	captchaGroup.Use(CaptchaCorsMiddleware())
 
 	// Handle OPTIONS requests explicitly for captcha endpoints
-	httpsRouter.OPTIONS("/captcha/*path", func(c *gin.Context) {
+	r.OPTIONS("/captcha/*path", func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
@@ -68,7 +50,6 @@ func SetupRoutes(httpAddr, httpsAddr string) {
 		c.AbortWithStatus(http.StatusNoContent)
 	})
 
-	// Add captcha endpoints to HTTP router with custom CORS handlers
 	captchaGroup.GET("/new", func(c *gin.Context) {
 		captchaId := captcha.NewLen(6)
 		c.JSON(http.StatusOK, gin.H{"captchaId": captchaId})
@@ -81,27 +62,40 @@ func SetupRoutes(httpAddr, httpsAddr string) {
 			c.AbortWithStatus(http.StatusInternalServerError)
 		}
 	})
+}
+
+// SetupRoutes configures and returns the Gin engine.
+func SetupRoutes(httpAddr, httpsAddr string) {
+	httpsRouter := gin.New()
+	httpsRouter.Use(tracing.Middleware(), middleware.AccessLogMiddleware, middleware.MetricsMiddleware, gin.Recovery())
+
+	// Enable CORS for frontend requests.
+	corsConfig := cors.Config{
+		AllowOrigins:     []string{"*"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept", "X-Requested-With"},
+		ExposeHeaders:    []string{"Content-Length"},
+		AllowCredentials: true,
+		MaxAge:           12 * 60 * 60, // 12 hours
+	}
+
+	httpsRouter.Use(cors.New(corsConfig))
+
+	registerCaptchaRoutes(httpsRouter)
+
+	// Probes live at the root, outside BASE_API. Metrics have their own
+	// listener, see serve.
+	httpsRouter.GET("/healthz", handlers.HealthzHandler)
+	httpsRouter.GET("/readyz", handlers.ReadyzHandler)
 
     rootGroup := httpsRouter.Group(config.BaseApi)
 
     // Create a dedicated group for dynamic endpoints.
 	var dynamicGroup *gin.RouterGroup = httpsRouter.Group(config.BaseApi)
 
+	// gRPC endpoints are served at the root: clients call /package.Service/Method.
+	grpcGroup := httpsRouter.Group("/")
 
-	// Only redirect non-captcha routes to HTTPS
-	// httpRouter.NoRoute(func(c *gin.Context) {
-	//     if !strings.HasPrefix(c.Request.URL.Path, "/captcha/") {
-	//         host := c.Request.Host
-	//         if h, _, err := net.SplitHostPort(host); err == nil {
-	//             host = h
-	//         }
-	//         target := fmt.Sprintf("https://%s%s", host, c.Request.RequestURI)
-	//         c.Redirect(http.StatusMovedPermanently, target)
-	//     } else {
-	//         // Handle 404 for captcha routes that don't exist
-	//         c.AbortWithStatus(http.StatusNotFound)
-	//     }
-	// })
 
 	// redirect /swagger to /swagger/index.html
 	rootGroup.GET("/swagger", func(c *gin.Context) {
@@ -129,7 +123,69 @@ func SetupRoutes(httpAddr, httpsAddr string) {
 	rootGroup.POST("/admin/customendpoints",
 		middleware.AuthMiddleware,
 		middleware.RoleMiddleware("admin"),
-		handlers.CreateCustomEndpointHandler(dynamicGroup),
+		handlers.CreateCustomEndpointHandler(dynamicGroup, grpcGroup),
+	)
+
+	rootGroup.GET("/admin/customendpoints/health",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.UpstreamHealthHandler,
+	)
+
+	rootGroup.PUT("/admin/customendpoints/:id/weights",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.UpdateGroupWeightsHandler,
+	)
+
+	rootGroup.DELETE("/admin/cache",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.PurgeCacheHandler,
+	)
+
+	// Audit log (Admin Only)
+	rootGroup.GET("/admin/audit",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.AuditLogHandler,
+	)
+
+	rootGroup.GET("/admin/audit/export",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.ExportAuditLogHandler,
+	)
+
+	rootGroup.GET("/admin/audit/verify",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.VerifyAuditLogHandler,
+	)
+
+	// Usage quotas (Admin Only)
+	rootGroup.POST("/admin/quotas",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.CreateQuotaHandler,
+	)
+
+	rootGroup.GET("/admin/quotas",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.GetQuotasHandler,
+	)
+
+	rootGroup.DELETE("/admin/quotas/:id",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.DeleteQuotaHandler,
+	)
+
+	// Remaining allowance of the caller's quotas.
+	rootGroup.GET("/me/quotas",
+		middleware.AuthMiddleware,
+		handlers.MyQuotasHandler,
 	)
 
 	// httpsRouter.DELETE("/admin/customendpoints/:endpoint",
@@ -139,7 +195,7 @@ func SetupRoutes(httpAddr, httpsAddr string) {
 	// )
 
 	// Dynamically register the custom endpoints from the database.
-	handlers.RegisterCustomEndpoints(rootGroup)
+	handlers.RegisterCustomEndpoints(rootGroup, grpcGroup)
 
 	// Add new User Endpoint (Admin Only)
 	rootGroup.POST("/users",
@@ -162,6 +218,20 @@ func SetupRoutes(httpAddr, httpsAddr string) {
 		handlers.UpdateUserRoleHandler,
 	)
 
+	// Set a User's balance through the accounting service (Admin Only)
+	rootGroup.PUT("/users/:username/balance",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.UpdateUserBalanceHandler,
+	)
+
+	// Map a client certificate to a User (Admin Only)
+	rootGroup.PUT("/users/:username/certificate",
+		middleware.AuthMiddleware,
+		middleware.RoleMiddleware("admin"),
+		handlers.UpdateUserCertificateHandler,
+	)
+
 	// Create New Role (Admin Only)
 	rootGroup.POST("/roles",
 		middleware.AuthMiddleware,
@@ -175,15 +245,5 @@ func SetupRoutes(httpAddr, httpsAddr string) {
 		handlers.GetRolesHandler,
 	)
 
-	// Launch both servers
-	// go func() {
-	//     if err := httpRouter.Run(httpAddr); err != nil {
-	//         log.Fatal("HTTP redirection server failed:", err)
-	//     }
-	// }()
-	// if err := httpsRouter.RunTLS(httpsAddr, "cert.pem", "key.pem"); err != nil {
-	// if err := httpsRouter.RunTLS(httpsAddr, "localhost.pem", "localhost-key.pem"); err != nil {
-	if err := httpsRouter.RunTLS(httpsAddr, config.TLSPath + "/172.26.249.184.pem", config.TLSPath + "/172.26.249.184-key.pem"); err != nil {
-		log.Fatal("Failed to start HTTPS server:", err)
-	}
+	serve(httpsRouter, httpAddr, httpsAddr)
 }
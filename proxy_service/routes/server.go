@@ -0,0 +1,161 @@
+package routes
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"auth_service/config"
+	"auth_service/handlers"
+	"auth_service/logging"
+	"auth_service/metrics"
+	"auth_service/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newServer returns an HTTP server for handler on addr with the configured
+// timeouts.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       config.ServerReadTimeout,
+		ReadHeaderTimeout: config.ServerReadHeaderTimeout,
+		WriteTimeout:      config.ServerWriteTimeout,
+		IdleTimeout:       config.ServerIdleTimeout,
+	}
+}
+
+// serve runs the gateway until SIGINT or SIGTERM, then drains it. In plain
+// mode handler is served over HTTP on httpAddr, TLS being terminated in
+// front of the gateway. Otherwise it is served over HTTPS on httpsAddr,
+// optionally with a listener on httpAddr redirecting to it. Metrics are
+// served on their own internal listener, config.MetricsAddr.
+func serve(handler http.Handler, httpAddr, httpsAddr string) {
+	var servers []*http.Server
+	errs := make(chan error, 3)
+
+	if config.MetricsAddr != "none" {
+		server := newServer(config.MetricsAddr, metricsRouter())
+		servers = append(servers, server)
+		slog.Info("Serving metrics", "addr", config.MetricsAddr)
+		go func() { errs <- server.ListenAndServe() }()
+	}
+
+	if config.TLSMode == "plain" {
+		if config.ClientCAFile != "" {
+			slog.Warn("CLIENT_CA_FILE is ignored in plain mode: client certificates end at the load balancer")
+		}
+		server := newServer(httpAddr, handler)
+		// gRPC clients behind the load balancer speak HTTP/2 with prior
+		// knowledge (h2c).
+		server.Protocols = new(http.Protocols)
+		server.Protocols.SetHTTP1(true)
+		server.Protocols.SetUnencryptedHTTP2(true)
+		servers = append(servers, server)
+		slog.Info("Serving plain HTTP and h2c", "addr", httpAddr)
+		go func() { errs <- server.ListenAndServe() }()
+	} else {
+		certs := newCertStore()
+		go certs.watch()
+
+		if config.HTTPRedirect {
+			redirect := newServer(httpAddr, redirectRouter(httpsAddr))
+			servers = append(servers, redirect)
+			go func() { errs <- redirect.ListenAndServe() }()
+		}
+
+		server := newServer(httpsAddr, handler)
+		server.TLSConfig = certs.tlsConfig()
+		servers = append(servers, server)
+		go func() { errs <- server.ListenAndServeTLS("", "") }()
+	}
+	handlers.SetServing(true)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-errs:
+		logging.Fatal("Failed to start server", "error", err)
+	case <-ctx.Done():
+	}
+	shutdown(servers)
+}
+
+// shutdown reports not-ready, waits config.ShutdownDelay for load balancers
+// to notice, then stops accepting connections and waits up to
+// config.ShutdownTimeout for in-flight requests. Requests still running
+// after that are cut off.
+func shutdown(servers []*http.Server) {
+	handlers.SetServing(false)
+	slog.Info("Shutting down: not ready, draining", "delay", config.ShutdownDelay.String())
+	time.Sleep(config.ShutdownDelay)
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Warn("Server did not drain in time, closing remaining connections", "addr", server.Addr, "error", err)
+				server.Close()
+			}
+		}(server)
+	}
+	wg.Wait()
+	slog.Info("Servers stopped")
+}
+
+// metricsRouter serves /metrics on the internal metrics listener.
+func metricsRouter() *gin.Engine {
+	router := gin.New()
+	router.Use(gin.Recovery())
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	return router
+}
+
+// redirectRouter serves the captcha endpoints and health probes over plain
+// HTTP and redirects every other request to the same URL on HTTPS.
+func redirectRouter(httpsAddr string) *gin.Engine {
+	router := gin.New()
+	router.Use(middleware.AccessLogMiddleware, gin.Recovery())
+	registerCaptchaRoutes(router)
+	router.GET("/healthz", handlers.HealthzHandler)
+	router.GET("/readyz", handlers.ReadyzHandler)
+
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	router.NoRoute(func(c *gin.Context) {
+		if strings.HasPrefix(c.Request.URL.Path, "/captcha/") {
+			// Handle 404 for captcha routes that don't exist
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		host := c.Request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+
+		// 308 keeps the method and body of non-GET requests.
+		status := http.StatusPermanentRedirect
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		c.Redirect(status, "https://"+host+c.Request.URL.RequestURI())
+	})
+	return router
+}
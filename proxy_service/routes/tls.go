@@ -0,0 +1,160 @@
+package routes
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"auth_service/config"
+	"auth_service/logging"
+)
+
+// certStore holds the server certificates and client CA bundle of the HTTPS
+// listener. Reloads only affect new handshakes, so open connections are
+// never dropped.
+type certStore struct {
+	mu      sync.RWMutex
+	current *tls.Config
+	mod     time.Time
+}
+
+// newCertStore loads the configured certificates or exits.
+func newCertStore() *certStore {
+	s := &certStore{}
+	if err := s.reload(); err != nil {
+		logging.Fatal("Failed to load TLS certificates", "error", err)
+	}
+	return s
+}
+
+// tlsConfig returns the listener configuration, resolving the current
+// certificates on every handshake.
+func (s *certStore) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return s.current, nil
+		},
+	}
+}
+
+// files returns the paths of all certificate, key and CA files.
+func (s *certStore) files() []string {
+	files := []string{tlsFile(config.TLSCertFile), tlsFile(config.TLSKeyFile)}
+	for _, pair := range config.TLSSNICerts {
+		files = append(files, tlsFile(pair.CertFile), tlsFile(pair.KeyFile))
+	}
+	if config.ClientCAFile != "" {
+		files = append(files, tlsFile(config.ClientCAFile))
+	}
+	return files
+}
+
+// reload loads all files. On failure the previous configuration stays in
+// use.
+func (s *certStore) reload() error {
+	mod, err := latestModTime(s.files())
+	if err != nil {
+		return err
+	}
+
+	// The default certificate comes first: it is served when no other one
+	// fits the requested server name.
+	pairs := append([]config.CertKeyPair{{CertFile: config.TLSCertFile, KeyFile: config.TLSKeyFile}}, config.TLSSNICerts...)
+	certs := make([]tls.Certificate, 0, len(pairs))
+	for _, pair := range pairs {
+		cert, err := tls.LoadX509KeyPair(tlsFile(pair.CertFile), tlsFile(pair.KeyFile))
+		if err != nil {
+			return fmt.Errorf("loading %s: %w", pair.CertFile, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	cfg := &tls.Config{
+		Certificates: certs,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+	if config.ClientCAFile != "" {
+		pem, err := os.ReadFile(tlsFile(config.ClientCAFile))
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("no certificates found in client CA bundle " + config.ClientCAFile)
+		}
+		// Certificates stay optional: callers without one authenticate
+		// with a JWT as before.
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		cfg.ClientCAs = pool
+	}
+
+	s.mu.Lock()
+	s.current, s.mod = cfg, mod
+	s.mu.Unlock()
+	return nil
+}
+
+// watch reloads the certificates on SIGHUP and whenever their files change.
+func (s *certStore) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var tick <-chan time.Time
+	if config.TLSReloadInterval > 0 {
+		ticker := time.NewTicker(config.TLSReloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-hup:
+			slog.Info("SIGHUP received, reloading TLS certificates")
+		case <-tick:
+			mod, err := latestModTime(s.files())
+			s.mu.RLock()
+			unchanged := err == nil && !mod.After(s.mod)
+			s.mu.RUnlock()
+			if unchanged {
+				continue
+			}
+		}
+
+		if err := s.reload(); err != nil {
+			slog.Warn("Keeping previous TLS certificates, reload failed", "error", err)
+			continue
+		}
+		slog.Info("TLS certificates reloaded")
+	}
+}
+
+// tlsFile resolves name under config.TLSPath.
+func tlsFile(name string) string {
+	return filepath.Join(config.TLSPath, name)
+}
+
+// latestModTime returns the latest modification time of paths.
+func latestModTime(paths []string) (time.Time, error) {
+	var latest time.Time
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
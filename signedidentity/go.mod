@@ -0,0 +1,3 @@
+module signedidentity
+
+go 1.24.2
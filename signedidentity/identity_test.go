@@ -0,0 +1,67 @@
+package signedidentity
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// goldenSignature is the signature of the fixture below. The gateway's
+// proxy.SignIdentity must produce the same value.
+const goldenSignature = "5e82f4ab78a96afef7862dabe942d060cbce633501318b7f63c89b2fc3836f56"
+
+func TestSignGolden(t *testing.T) {
+	if got := Sign("secret", "alice", "admin", "acme", "req-1", "1700000000"); got != goldenSignature {
+		t.Fatalf("Sign = %s, want %s", got, goldenSignature)
+	}
+}
+
+func signed(secret string, now time.Time) http.Header {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	h := http.Header{}
+	h.Set(HeaderUser, "alice")
+	h.Set(HeaderRole, "admin")
+	h.Set(HeaderTenant, "acme")
+	h.Set(HeaderRequestID, "req-1")
+	h.Set(HeaderTimestamp, timestamp)
+	h.Set(HeaderSignature, Sign(secret, "alice", "admin", "acme", "req-1", timestamp))
+	return h
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	c, err := Verify("secret", signed("secret", now), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != (Caller{User: "alice", Role: "admin", Tenant: "acme", RequestID: "req-1"}) {
+		t.Fatalf("got %+v", c)
+	}
+
+	tampered := signed("secret", now)
+	tampered.Set(HeaderRole, "root")
+
+	cases := []struct {
+		name   string
+		secret string
+		h      http.Header
+		now    time.Time
+		want   error
+	}{
+		{"no secret", "", signed("secret", now), now, ErrNoSecret},
+		{"no headers", "secret", http.Header{}, now, ErrMissing},
+		{"stale", "secret", signed("secret", now), now.Add(MaxSkew + time.Second), ErrStale},
+		{"other secret", "secret", signed("other", now), now, ErrSignature},
+		{"tampered", "secret", tampered, now, ErrSignature},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := Verify(tc.secret, tc.h, tc.now); !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIDHeader carries the request ID assigned by the gateway.
+const requestIDHeader = "X-Request-ID"
+
+// initLogging makes a JSON logger writing to stdout the default for both
+// slog and the log package.
+func initLogging() {
+	log.SetFlags(0)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+}
+
+// AccessLogMiddleware writes one JSON access log line per request under the
+// gateway's request ID.
+func AccessLogMiddleware(c *gin.Context) {
+	start := time.Now()
+	c.Next()
+
+	attrs := []slog.Attr{
+		slog.String("request_id", c.GetHeader(requestIDHeader)),
+		slog.String("method", c.Request.Method),
+		slog.String("route", c.FullPath()),
+		slog.Int("status", c.Writer.Status()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+	}
+	if user := c.GetString("user"); user != "" {
+		attrs = append(attrs, slog.String("user", user))
+	}
+	slog.LogAttrs(c.Request.Context(), slog.LevelInfo, "request", attrs...)
+}
@@ -1,12 +1,11 @@
 package main
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
-	"strings"
+	"os"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
@@ -20,59 +19,30 @@ type Message struct {
 var messages = make(map[string]Message)
 
 func main() {
-    r := gin.Default()
+    initLogging()
+    if requireIdentity && identitySecret == "" {
+        slog.Error("REQUIRE_IDENTITY is set but IDENTITY_SECRET is not")
+        os.Exit(1)
+    }
+
+    r := gin.New()
+    r.Use(AccessLogMiddleware, gin.Recovery())
 
     // Final Service Endpoints
-    r.POST("/sms/sendsms", func(c *gin.Context) {
+    r.POST("/sms/sendsms", IdentityMiddleware, func(c *gin.Context) {
         var msg Message
         if err := c.ShouldBindJSON(&msg); err != nil {
             c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format"})
             return
         }
 
-        authHeader := c.GetHeader("Authorization")
-        if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-            c.Abort()
-            return
-        }
-        tokenStr := strings.TrimPrefix(authHeader, "Bearer ")
-        token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-            // Ensure the signing method is HMAC.
-            if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-                return nil, jwt.ErrSignatureInvalid
-            }
-            SECRET_KEY:="f78973efc0c0664995e2bb055bb2cac6779597a5294685f069229c909358f54a"
-            return []byte(SECRET_KEY), nil
-            // return []byte(config.SecretKey), nil
-        })
-        if err != nil || !token.Valid {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
-            c.Abort()
-            return
-        }
-
-        claims, ok := token.Claims.(jwt.MapClaims)
-        if !ok {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
-            c.Abort()
-            return
-        }
-
-		user, ok := claims["user"].(string)
-		if !ok {
-			c.JSON(http.StatusForbidden, gin.H{"error": "Username not present in token"})
-			c.Abort()
-			return
-		}
-        fmt.Printf("user is %s\n", user)
-
         // Generate a unique message ID
         messageID := uuid.New().String()
         msg.Status = "Sent"
 
         // Store the message
         messages[messageID] = msg
+        slog.InfoContext(c.Request.Context(), "Message accepted", "request_id", c.GetHeader(requestIDHeader), "user", c.GetString("user"), "message_id", messageID)
 
         // Respond with the message ID
         c.JSON(http.StatusOK, gin.H{"message-id": messageID})
@@ -100,6 +70,24 @@ func main() {
         c.String(http.StatusOK, "Welcome to the SMS Service!")
     })
 
+    // Liveness and readiness probes.
+    r.GET("/healthz", func(c *gin.Context) {
+        c.JSON(http.StatusOK, gin.H{"status": "ok"})
+    })
+
+    r.GET("/readyz", func(c *gin.Context) {
+        // Without the shared secret no signed identity can be verified,
+        // which only keeps the service from working once identities are
+        // required.
+        identity := gin.H{"status": "ok", "required": requireIdentity}
+        if identitySecret == "" && requireIdentity {
+            identity = gin.H{"status": "error", "error": "IDENTITY_SECRET is not set"}
+            c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "checks": gin.H{"identity": identity}})
+            return
+        }
+        c.JSON(http.StatusOK, gin.H{"status": "ready", "checks": gin.H{"identity": identity}})
+    })
+
     // Run the Final Service
     r.Run(":8081") // This runs the final service on port 8081
 }